@@ -0,0 +1,74 @@
+package main
+
+// 字节码指令，注释中为指令的操作数
+const (
+	OP_CONSTANT        uint8 = iota // 常量下标(2字节)
+	OP_NIL                          //
+	OP_TRUE                         //
+	OP_FALSE                        //
+	OP_POP                          //
+	OP_GET_LOCAL                    // 局部变量槽位(1字节)
+	OP_SET_LOCAL                    // 局部变量槽位(1字节)
+	OP_GET_UPVALUE                  // 上值下标(1字节)
+	OP_SET_UPVALUE                  // 上值下标(1字节)
+	OP_GET_GLOBAL                   // 变量名常量下标(2字节)
+	OP_DEFINE_GLOBAL                // 变量名常量下标(2字节)
+	OP_SET_GLOBAL                   // 变量名常量下标(2字节)
+	OP_GET_PROPERTY                 // 属性名常量下标(2字节)
+	OP_SET_PROPERTY                 // 属性名常量下标(2字节)
+	OP_GET_SUPER                    // 方法名常量下标(2字节)
+	OP_GET_INDEX                    //
+	OP_SET_INDEX                    //
+	OP_LIST                         // 元素个数(2字节)
+	OP_MAP                          // 键值对个数(2字节)
+	OP_UNARY                        // 运算符下标(2字节)
+	OP_BINARY                       // 运算符下标(2字节)
+	OP_STRINGIFY                    //
+	OP_PRINT                        //
+	OP_JUMP                         // 向前跳转的偏移量(2字节)
+	OP_JUMP_IF_FALSE                // 向前跳转的偏移量(2字节)，不弹出条件值
+	OP_JUMP_IF_NOT_NIL              // 向前跳转的偏移量(2字节)，不弹出条件值
+	OP_LOOP                         // 向后跳转的偏移量(2字节)
+	OP_CALL                         // 参数个数(1字节)
+	OP_CLOSURE                      // 函数原型常量下标(2字节)，之后每个上值2字节：是否为外层局部变量、槽位或上值下标
+	OP_CLOSE_UPVALUE                //
+	OP_RETURN                       //
+	OP_CLASS                        // 类名常量下标(2字节)
+	OP_INHERIT                      //
+	OP_METHOD                       // 方法名常量下标(2字节)
+	OP_IMPORT                       // 模块路径常量下标(2字节)
+	OP_IMPORT_ALL                   //
+	OP_THROW                        //
+	OP_TRY                          // 出错时向前跳转的偏移量(2字节)
+	OP_END_TRY                      //
+	OP_CATCH                        //
+	OP_RETHROW                      //
+)
+
+// Chunk 一个函数编译后的字节码
+type Chunk struct {
+	code      []byte
+	lines     []int   // 每个字节对应的源代码行号
+	constants []Value // 常量池
+	operators []Token // 运算符，虚拟机报错时使用其中的行号和运算符
+}
+
+func (chunk *Chunk) write(b byte, line int) {
+	chunk.code = append(chunk.code, b)
+	chunk.lines = append(chunk.lines, line)
+}
+
+func (chunk *Chunk) addConstant(value Value) int {
+	chunk.constants = append(chunk.constants, value)
+	return len(chunk.constants) - 1
+}
+
+func (chunk *Chunk) addOperator(operator Token) int {
+	chunk.operators = append(chunk.operators, operator)
+	return len(chunk.operators) - 1
+}
+
+// 读取从offset开始的2字节操作数
+func (chunk *Chunk) readShort(offset int) int {
+	return int(chunk.code[offset])<<8 | int(chunk.code[offset+1])
+}
@@ -0,0 +1,57 @@
+package main
+
+// Class 运行时的类对象，调用类即创建实例
+type Class struct {
+	name       string
+	superclass *Class
+	methods    map[string]*Function
+}
+
+// Instance 运行时的类实例
+type Instance struct {
+	class  *Class
+	fields map[string]Value
+}
+
+// 查找方法，当前类中找不到时沿父类链向上查找
+func (class *Class) findMethod(name string) (*Function, bool) {
+	if method, ok := class.methods[name]; ok {
+		return method, true
+	}
+	if class.superclass != nil {
+		return class.superclass.findMethod(name)
+	}
+	return nil, false
+}
+
+func (class *Class) call(interpreter *Interpreter, paren Token, args []Value) Value {
+	instance := &Instance{class, map[string]Value{}}
+	if initializer, ok := class.findMethod("init"); ok {
+		initializer.bind(instance).call(interpreter, paren, args)
+	}
+	return objectValue(instance)
+}
+
+// 类的参数个数由初始化方法init决定
+func (class *Class) arity() int {
+	if initializer, ok := class.findMethod("init"); ok {
+		return initializer.arity()
+	}
+	return 0
+}
+
+// 读取属性，字段优先于方法
+func (instance *Instance) get(name Token) Value {
+	if value, ok := instance.fields[name.lexeme]; ok {
+		return value
+	}
+	if method, ok := instance.class.findMethod(name.lexeme); ok {
+		return objectValue(method.bind(instance))
+	}
+	runtimeError(name.line, "Undefined property '"+name.lexeme+"'.")
+	return nilValue
+}
+
+func (instance *Instance) set(name Token, value Value) {
+	instance.fields[name.lexeme] = value
+}
@@ -0,0 +1,738 @@
+package main
+
+import "math"
+
+// 编译期的局部变量
+type local struct {
+	name     string
+	depth    int  // 所在作用域深度，-1表示已声明但尚未完成定义
+	captured bool // 是否被内层函数捕获为上值
+}
+
+// 编译期的上值，记录它引用的是外层函数的局部变量还是外层函数的上值
+type upvalue struct {
+	index   uint8
+	isLocal bool
+}
+
+// 编译中的循环，记录需要回填跳转目标的break和continue
+type loop struct {
+	depth     int   // 循环所在的作用域深度
+	breaks    []int // break跳转指令的操作数位置
+	continues []int // continue跳转指令的操作数位置
+}
+
+// 编译中的try语句，return、break、continue离开时需要先注销错误处理再执行finally块
+type tryBlock struct {
+	finally Stmt // 没有finally时为nil
+	loops   int  // try语句外层的循环数
+}
+
+// 编译中的类
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// Compiler 将静态解析后的语法树编译为字节码，每个函数对应一个Compiler
+type Compiler struct {
+	enclosing *Compiler
+	proto     *Prototype
+	kind      uint8          // 函数类型，取值与Resolver相同
+	locals    []local        // 局部变量，下标即栈上的槽位
+	upvalues  []upvalue      // 函数捕获的上值
+	names     map[string]int // 标识符常量的下标，避免重复加入常量池
+	depth     int            // 当前作用域深度，0为全局作用域
+	loops     []*loop
+	tries     []tryBlock // 当前位置外层已注册错误处理的try语句
+	class     *classCompiler
+	line      int // 当前生成指令对应的源代码行号
+}
+
+func _Compiler(enclosing *Compiler, kind uint8, name string) *Compiler {
+	compiler := &Compiler{
+		enclosing: enclosing,
+		proto:     &Prototype{name: name},
+		kind:      kind,
+		names:     map[string]int{},
+	}
+	if enclosing != nil {
+		compiler.class = enclosing.class
+		compiler.line = enclosing.line
+	}
+	// 槽位0保存被调用的函数，方法中则保存this
+	slot0 := ""
+	if kind == methodFunction || kind == initializerFunction {
+		slot0 = "this"
+	}
+	compiler.locals = append(compiler.locals, local{name: slot0})
+	return compiler
+}
+
+// 将顶层语句编译为脚本函数，返回遇到的第一个错误
+func compileScript(stmts []Stmt) (script *Prototype, err error) {
+	defer catch(&err)
+	compiler := _Compiler(nil, noneFunction, "")
+	for _, stmt := range stmts {
+		stmt.compile(compiler)
+	}
+	compiler.emitReturn()
+	return compiler.proto, nil
+}
+
+/*  ===================  指令生成  ===================  */
+
+func (compiler *Compiler) chunk() *Chunk {
+	return &compiler.proto.chunk
+}
+
+func (compiler *Compiler) emit(bytes ...byte) {
+	for _, b := range bytes {
+		compiler.chunk().write(b, compiler.line)
+	}
+}
+
+func (compiler *Compiler) emitShort(value int) {
+	compiler.emit(byte(value>>8), byte(value))
+}
+
+// 生成带2字节操作数的指令
+func (compiler *Compiler) emitOp(op uint8, operand int) {
+	compiler.emit(op)
+	compiler.emitShort(operand)
+}
+
+func (compiler *Compiler) emitReturn() {
+	compiler.emitDefaultResult()
+	compiler.emit(OP_RETURN)
+}
+
+// 没有返回值时的结果，初始化方法总是返回实例本身
+func (compiler *Compiler) emitDefaultResult() {
+	if compiler.kind == initializerFunction {
+		compiler.emit(OP_GET_LOCAL, 0)
+	} else {
+		compiler.emit(OP_NIL)
+	}
+}
+
+func (compiler *Compiler) makeConstant(value Value) int {
+	index := compiler.chunk().addConstant(value)
+	if index > math.MaxUint16 {
+		parseError(compiler.line, "Too many constants in one chunk.")
+	}
+	return index
+}
+
+func (compiler *Compiler) emitConstant(value Value) {
+	compiler.emitOp(OP_CONSTANT, compiler.makeConstant(value))
+}
+
+// 保存运算符token，虚拟机报错时使用其中的行号和运算符
+func (compiler *Compiler) operator(operator Token) int {
+	index := compiler.chunk().addOperator(operator)
+	if index > math.MaxUint16 {
+		parseError(compiler.line, "Too many operators in one chunk.")
+	}
+	return index
+}
+
+// 将标识符加入常量池，同名标识符共用一个常量
+func (compiler *Compiler) identifier(name string) int {
+	if index, ok := compiler.names[name]; ok {
+		return index
+	}
+	index := compiler.makeConstant(stringValue(name))
+	compiler.names[name] = index
+	return index
+}
+
+// 生成跳转指令，返回待回填的操作数位置
+func (compiler *Compiler) emitJump(op uint8) int {
+	compiler.emitOp(op, 0xffff)
+	return len(compiler.chunk().code) - 2
+}
+
+// 将跳转目标回填为当前位置
+func (compiler *Compiler) patchJump(offset int) {
+	jump := len(compiler.chunk().code) - offset - 2
+	if jump > math.MaxUint16 {
+		parseError(compiler.line, "Too much code to jump over.")
+	}
+	compiler.chunk().code[offset] = byte(jump >> 8)
+	compiler.chunk().code[offset+1] = byte(jump)
+}
+
+// 生成跳回start处的循环指令
+func (compiler *Compiler) emitLoop(start int) {
+	compiler.emit(OP_LOOP)
+	offset := len(compiler.chunk().code) - start + 2
+	if offset > math.MaxUint16 {
+		parseError(compiler.line, "Loop body too large.")
+	}
+	compiler.emitShort(offset)
+}
+
+/*  ===================  作用域与变量  ===================  */
+
+func (compiler *Compiler) beginScope() {
+	compiler.depth++
+}
+
+// 离开作用域时弹出其中的局部变量，被捕获的变量需要关闭对应的上值
+func (compiler *Compiler) endScope() {
+	compiler.depth--
+	for len(compiler.locals) > 0 && compiler.locals[len(compiler.locals)-1].depth > compiler.depth {
+		if compiler.locals[len(compiler.locals)-1].captured {
+			compiler.emit(OP_CLOSE_UPVALUE)
+		} else {
+			compiler.emit(OP_POP)
+		}
+		compiler.locals = compiler.locals[:len(compiler.locals)-1]
+	}
+}
+
+// break和continue跳出作用域时弹出比depth更深的局部变量，编译期的变量表保持不变
+func (compiler *Compiler) discardLocals(depth int) {
+	for i := len(compiler.locals) - 1; i >= 0 && compiler.locals[i].depth > depth; i-- {
+		if compiler.locals[i].captured {
+			compiler.emit(OP_CLOSE_UPVALUE)
+		} else {
+			compiler.emit(OP_POP)
+		}
+	}
+}
+
+// 在局部作用域中声明变量，全局变量不需要声明
+func (compiler *Compiler) declare(name string) {
+	if compiler.depth == 0 {
+		return
+	}
+	if len(compiler.locals) > math.MaxUint8 {
+		parseError(compiler.line, "Too many local variables in function.")
+	}
+	compiler.locals = append(compiler.locals, local{name: name, depth: -1})
+}
+
+// 完成变量定义，全局变量通过指令写入全局变量表，局部变量的值已在栈上
+func (compiler *Compiler) define(name string) {
+	if compiler.depth == 0 {
+		compiler.emitOp(OP_DEFINE_GLOBAL, compiler.identifier(name))
+		return
+	}
+	compiler.locals[len(compiler.locals)-1].depth = compiler.depth
+}
+
+// 栈顶的值在编译期记为匿名局部变量，使之后声明的局部变量槽位与值栈一致
+func (compiler *Compiler) holdTemporary() {
+	compiler.beginScope()
+	compiler.declare("")
+	compiler.define("")
+}
+
+// 编译期移除匿名局部变量，值仍留在栈上由之后的指令使用
+func (compiler *Compiler) releaseTemporary() {
+	compiler.locals = compiler.locals[:len(compiler.locals)-1]
+	compiler.depth--
+}
+
+func (compiler *Compiler) resolveLocal(name string) int {
+	for i := len(compiler.locals) - 1; i >= 0; i-- {
+		if compiler.locals[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 在外层函数中查找变量并逐层记录为上值，找不到时为全局变量
+func (compiler *Compiler) resolveUpvalue(name string) int {
+	if compiler.enclosing == nil {
+		return -1
+	}
+	if slot := compiler.enclosing.resolveLocal(name); slot >= 0 {
+		compiler.enclosing.locals[slot].captured = true
+		return compiler.addUpvalue(uint8(slot), true)
+	}
+	if index := compiler.enclosing.resolveUpvalue(name); index >= 0 {
+		return compiler.addUpvalue(uint8(index), false)
+	}
+	return -1
+}
+
+func (compiler *Compiler) addUpvalue(index uint8, isLocal bool) int {
+	for i, u := range compiler.upvalues {
+		if u.index == index && u.isLocal == isLocal {
+			return i
+		}
+	}
+	if len(compiler.upvalues) > math.MaxUint8 {
+		parseError(compiler.line, "Too many closure variables in function.")
+	}
+	compiler.upvalues = append(compiler.upvalues, upvalue{index, isLocal})
+	compiler.proto.upvalueCount = len(compiler.upvalues)
+	return len(compiler.upvalues) - 1
+}
+
+// 读取变量
+func (compiler *Compiler) getVariable(name string) {
+	if slot := compiler.resolveLocal(name); slot >= 0 {
+		compiler.emit(OP_GET_LOCAL, byte(slot))
+	} else if index := compiler.resolveUpvalue(name); index >= 0 {
+		compiler.emit(OP_GET_UPVALUE, byte(index))
+	} else {
+		compiler.emitOp(OP_GET_GLOBAL, compiler.identifier(name))
+	}
+}
+
+// 将栈顶的值赋给变量，值仍保留在栈顶
+func (compiler *Compiler) setVariable(name string) {
+	if slot := compiler.resolveLocal(name); slot >= 0 {
+		compiler.emit(OP_SET_LOCAL, byte(slot))
+	} else if index := compiler.resolveUpvalue(name); index >= 0 {
+		compiler.emit(OP_SET_UPVALUE, byte(index))
+	} else {
+		compiler.emitOp(OP_SET_GLOBAL, compiler.identifier(name))
+	}
+}
+
+// 编译函数体，并在当前函数中生成创建闭包的指令
+func (compiler *Compiler) function(declaration *functionStmt, kind uint8) {
+	child := _Compiler(compiler, kind, declaration.name.lexeme)
+	child.proto.arity = len(declaration.params)
+	child.beginScope()
+	for _, param := range declaration.params {
+		child.declare(param.lexeme)
+		child.define(param.lexeme)
+	}
+	for _, stmt := range declaration.stmts {
+		stmt.compile(child)
+	}
+	child.emitReturn()
+
+	compiler.emitOp(OP_CLOSURE, compiler.makeConstant(objectValue(child.proto)))
+	for _, u := range child.upvalues {
+		isLocal := byte(0)
+		if u.isLocal {
+			isLocal = 1
+		}
+		compiler.emit(isLocal, u.index)
+	}
+}
+
+// 离开下标不小于first的try语句：由内向外注销错误处理并执行finally块
+// finally块中只有更外层的try语句仍然有效
+func (compiler *Compiler) exitTries(first int) {
+	tries := compiler.tries
+	for i := len(tries) - 1; i >= first; i-- {
+		compiler.emit(OP_END_TRY)
+		if tries[i].finally != nil {
+			compiler.tries = tries[:i:i]
+			tries[i].finally.compile(compiler)
+		}
+	}
+	compiler.tries = tries
+}
+
+// 最内层循环中第一个try语句的下标
+func (compiler *Compiler) loopTries() int {
+	first := len(compiler.tries)
+	for first > 0 && compiler.tries[first-1].loops >= len(compiler.loops) {
+		first--
+	}
+	return first
+}
+
+// 执行finally块后重新抛出栈顶的错误，错误在此期间作为匿名局部变量保留在栈上
+func (compiler *Compiler) rethrow(finally Stmt) {
+	compiler.holdTemporary()
+	finally.compile(compiler)
+	compiler.emit(OP_RETHROW)
+	compiler.releaseTemporary()
+}
+
+/*  ===================  Statement  ===================  */
+
+func (e exprStmt) compile(compiler *Compiler) {
+	e.expr.compile(compiler)
+	compiler.emit(OP_POP)
+}
+
+func (p printStmt) compile(compiler *Compiler) {
+	p.expr.compile(compiler)
+	compiler.emit(OP_PRINT)
+}
+
+func (v varStmt) compile(compiler *Compiler) {
+	compiler.line = v.name.line
+	compiler.declare(v.name.lexeme)
+	if v.initializer != nil {
+		v.initializer.compile(compiler)
+	} else {
+		compiler.emit(OP_NIL)
+	}
+	compiler.line = v.name.line
+	compiler.define(v.name.lexeme)
+}
+
+func (b *blockStmt) compile(compiler *Compiler) {
+	compiler.beginScope()
+	for _, stmt := range b.stmts {
+		stmt.compile(compiler)
+	}
+	compiler.endScope()
+}
+
+func (i ifStmt) compile(compiler *Compiler) {
+	i.condition.compile(compiler)
+	thenJump := compiler.emitJump(OP_JUMP_IF_FALSE)
+	compiler.emit(OP_POP)
+	i.thenBranch.compile(compiler)
+	elseJump := compiler.emitJump(OP_JUMP)
+	compiler.patchJump(thenJump)
+	compiler.emit(OP_POP)
+	if i.elseBranch != nil {
+		i.elseBranch.compile(compiler)
+	}
+	compiler.patchJump(elseJump)
+}
+
+func (w whileStmt) compile(compiler *Compiler) {
+	start := len(compiler.chunk().code)
+	w.condition.compile(compiler)
+	exitJump := compiler.emitJump(OP_JUMP_IF_FALSE)
+	compiler.emit(OP_POP)
+
+	current := &loop{depth: compiler.depth}
+	compiler.loops = append(compiler.loops, current)
+	w.body.compile(compiler)
+	compiler.loops = compiler.loops[:len(compiler.loops)-1]
+
+	// continue跳转到自增表达式处
+	for _, offset := range current.continues {
+		compiler.patchJump(offset)
+	}
+	if w.increment != nil {
+		w.increment.compile(compiler)
+		compiler.emit(OP_POP)
+	}
+	compiler.emitLoop(start)
+
+	compiler.patchJump(exitJump)
+	compiler.emit(OP_POP)
+	for _, offset := range current.breaks {
+		compiler.patchJump(offset)
+	}
+}
+
+func (f *functionStmt) compile(compiler *Compiler) {
+	compiler.line = f.name.line
+	// 先定义函数名再编译函数体，使函数可以递归调用自身
+	compiler.declare(f.name.lexeme)
+	if compiler.depth > 0 {
+		compiler.define(f.name.lexeme)
+	}
+	compiler.function(f, plainFunction)
+	compiler.line = f.name.line
+	if compiler.depth == 0 {
+		compiler.define(f.name.lexeme)
+	}
+}
+
+func (c classStmt) compile(compiler *Compiler) {
+	compiler.line = c.name.line
+	name := compiler.identifier(c.name.lexeme)
+	compiler.declare(c.name.lexeme)
+	compiler.emitOp(OP_CLASS, name)
+	compiler.define(c.name.lexeme)
+
+	compiler.class = &classCompiler{enclosing: compiler.class}
+	if c.superclass != nil {
+		// 父类保存在名为super的局部变量中，供方法以上值的方式捕获
+		c.superclass.compile(compiler)
+		compiler.line = c.name.line
+		compiler.beginScope()
+		compiler.declare("super")
+		compiler.define("super")
+		compiler.getVariable(c.name.lexeme)
+		compiler.emit(OP_INHERIT)
+		compiler.class.hasSuperclass = true
+	}
+
+	// 方法定义期间类对象保留在栈顶
+	compiler.getVariable(c.name.lexeme)
+	for _, method := range c.methods {
+		kind := methodFunction
+		if method.name.lexeme == "init" {
+			kind = initializerFunction
+		}
+		compiler.function(method, kind)
+		compiler.line = method.name.line
+		compiler.emitOp(OP_METHOD, compiler.identifier(method.name.lexeme))
+	}
+	compiler.emit(OP_POP)
+
+	if compiler.class.hasSuperclass {
+		compiler.endScope()
+	}
+	compiler.class = compiler.class.enclosing
+}
+
+func (r returnStmt) compile(compiler *Compiler) {
+	compiler.line = r.keyword.line
+	if r.value == nil {
+		compiler.emitDefaultResult()
+	} else {
+		r.value.compile(compiler)
+	}
+	if len(compiler.tries) > 0 {
+		// 返回值保留在栈上，执行完外层try语句的finally块后再返回
+		compiler.line = r.keyword.line
+		compiler.holdTemporary()
+		compiler.exitTries(0)
+		compiler.releaseTemporary()
+	}
+	compiler.line = r.keyword.line
+	compiler.emit(OP_RETURN)
+}
+
+func (i importStmt) compile(compiler *Compiler) {
+	compiler.line = i.keyword.line
+	compiler.emitOp(OP_IMPORT, compiler.identifier(i.path.literal.(string)))
+	if i.alias == nil {
+		compiler.emit(OP_IMPORT_ALL)
+		return
+	}
+	compiler.declare(i.alias.lexeme)
+	compiler.define(i.alias.lexeme)
+}
+
+func (t throwStmt) compile(compiler *Compiler) {
+	t.value.compile(compiler)
+	compiler.line = t.keyword.line
+	compiler.emit(OP_THROW)
+}
+
+// 出错时虚拟机将值栈恢复到try语句开始时的高度，压入错误后跳转到catch或finally处
+func (t tryStmt) compile(compiler *Compiler) {
+	compiler.line = t.keyword.line
+	loops := len(compiler.loops)
+	handlerJump := compiler.emitJump(OP_TRY)
+	compiler.tries = append(compiler.tries, tryBlock{t.finallyBranch, loops})
+	t.body.compile(compiler)
+	compiler.tries = compiler.tries[:len(compiler.tries)-1]
+	compiler.emit(OP_END_TRY)
+	if t.finallyBranch != nil {
+		t.finallyBranch.compile(compiler)
+	}
+	endJump := compiler.emitJump(OP_JUMP)
+
+	compiler.patchJump(handlerJump)
+	if t.catchBranch == nil {
+		compiler.rethrow(t.finallyBranch)
+		compiler.patchJump(endJump)
+		return
+	}
+
+	// 错误转换为Lox值后作为catch绑定的局部变量
+	compiler.line = t.catchName.line
+	compiler.emit(OP_CATCH)
+	compiler.beginScope()
+	compiler.declare(t.catchName.lexeme)
+	compiler.define(t.catchName.lexeme)
+	if t.finallyBranch == nil {
+		t.catchBranch.compile(compiler)
+	} else {
+		// catch块中的错误同样要先执行finally块再抛出
+		rethrowJump := compiler.emitJump(OP_TRY)
+		compiler.tries = append(compiler.tries, tryBlock{t.finallyBranch, loops})
+		t.catchBranch.compile(compiler)
+		compiler.tries = compiler.tries[:len(compiler.tries)-1]
+		compiler.emit(OP_END_TRY)
+		catchEnd := compiler.emitJump(OP_JUMP)
+		compiler.patchJump(rethrowJump)
+		compiler.rethrow(t.finallyBranch)
+		compiler.patchJump(catchEnd)
+	}
+	compiler.endScope()
+	if t.finallyBranch != nil {
+		t.finallyBranch.compile(compiler)
+	}
+	compiler.patchJump(endJump)
+}
+
+func (b breakStmt) compile(compiler *Compiler) {
+	compiler.line = b.keyword.line
+	current := compiler.loops[len(compiler.loops)-1]
+	compiler.exitTries(compiler.loopTries())
+	compiler.line = b.keyword.line
+	compiler.discardLocals(current.depth)
+	current.breaks = append(current.breaks, compiler.emitJump(OP_JUMP))
+}
+
+func (c continueStmt) compile(compiler *Compiler) {
+	compiler.line = c.keyword.line
+	current := compiler.loops[len(compiler.loops)-1]
+	compiler.exitTries(compiler.loopTries())
+	compiler.line = c.keyword.line
+	compiler.discardLocals(current.depth)
+	current.continues = append(current.continues, compiler.emitJump(OP_JUMP))
+}
+
+/*  ===================  Expression  ===================  */
+
+func (l Literal) compile(compiler *Compiler) {
+	switch {
+	case l.value.isNil():
+		compiler.emit(OP_NIL)
+	case l.value == boolValue(true):
+		compiler.emit(OP_TRUE)
+	case l.value == boolValue(false):
+		compiler.emit(OP_FALSE)
+	default:
+		compiler.emitConstant(l.value)
+	}
+}
+
+func (u Unary) compile(compiler *Compiler) {
+	u.right.compile(compiler)
+	compiler.line = u.operator.line
+	compiler.emitOp(OP_UNARY, compiler.operator(u.operator))
+}
+
+func (b Binary) compile(compiler *Compiler) {
+	b.left.compile(compiler)
+	b.right.compile(compiler)
+	compiler.line = b.operator.line
+	compiler.emitOp(OP_BINARY, compiler.operator(b.operator))
+}
+
+func (g Grouping) compile(compiler *Compiler) {
+	g.expression.compile(compiler)
+}
+
+func (v *Variable) compile(compiler *Compiler) {
+	compiler.line = v.name.line
+	compiler.getVariable(v.name.lexeme)
+}
+
+func (a *Assign) compile(compiler *Compiler) {
+	a.value.compile(compiler)
+	compiler.line = a.name.line
+	compiler.setVariable(a.name.lexeme)
+}
+
+func (p Postfix) compile(compiler *Compiler) {
+	p.variable.compile(compiler)
+	p.assign.compile(compiler)
+	compiler.emit(OP_POP)
+}
+
+func (l Logical) compile(compiler *Compiler) {
+	l.left.compile(compiler)
+	var endJump int
+	switch l.operator.tokenType {
+	case OR:
+		elseJump := compiler.emitJump(OP_JUMP_IF_FALSE)
+		endJump = compiler.emitJump(OP_JUMP)
+		compiler.patchJump(elseJump)
+	case QUESTION_QUESTION:
+		endJump = compiler.emitJump(OP_JUMP_IF_NOT_NIL)
+	default:
+		endJump = compiler.emitJump(OP_JUMP_IF_FALSE)
+	}
+	compiler.emit(OP_POP)
+	l.right.compile(compiler)
+	compiler.patchJump(endJump)
+}
+
+func (c Conditional) compile(compiler *Compiler) {
+	c.condition.compile(compiler)
+	elseJump := compiler.emitJump(OP_JUMP_IF_FALSE)
+	compiler.emit(OP_POP)
+	c.thenBranch.compile(compiler)
+	endJump := compiler.emitJump(OP_JUMP)
+	compiler.patchJump(elseJump)
+	compiler.emit(OP_POP)
+	c.elseBranch.compile(compiler)
+	compiler.patchJump(endJump)
+}
+
+func (c Call) compile(compiler *Compiler) {
+	c.callee.compile(compiler)
+	for _, arg := range c.args {
+		arg.compile(compiler)
+	}
+	compiler.line = c.paren.line
+	if len(c.args) > math.MaxUint8 {
+		parseError(c.paren.line, "Can't have more than 255 arguments.")
+	}
+	compiler.emit(OP_CALL, byte(len(c.args)))
+}
+
+func (g Get) compile(compiler *Compiler) {
+	g.object.compile(compiler)
+	compiler.line = g.name.line
+	compiler.emitOp(OP_GET_PROPERTY, compiler.identifier(g.name.lexeme))
+}
+
+func (s Set) compile(compiler *Compiler) {
+	s.object.compile(compiler)
+	s.value.compile(compiler)
+	compiler.line = s.name.line
+	compiler.emitOp(OP_SET_PROPERTY, compiler.identifier(s.name.lexeme))
+}
+
+func (t *This) compile(compiler *Compiler) {
+	compiler.line = t.keyword.line
+	compiler.getVariable("this")
+}
+
+func (l ListLiteral) compile(compiler *Compiler) {
+	for _, element := range l.elements {
+		element.compile(compiler)
+	}
+	compiler.line = l.bracket.line
+	compiler.emitOp(OP_LIST, len(l.elements))
+}
+
+func (m MapLiteral) compile(compiler *Compiler) {
+	for i, key := range m.keys {
+		key.compile(compiler)
+		m.values[i].compile(compiler)
+	}
+	compiler.line = m.brace.line
+	compiler.emitOp(OP_MAP, len(m.keys))
+}
+
+func (i Index) compile(compiler *Compiler) {
+	i.object.compile(compiler)
+	i.index.compile(compiler)
+	compiler.line = i.bracket.line
+	compiler.emit(OP_GET_INDEX)
+}
+
+func (i IndexSet) compile(compiler *Compiler) {
+	i.object.compile(compiler)
+	i.index.compile(compiler)
+	i.value.compile(compiler)
+	compiler.line = i.bracket.line
+	compiler.emit(OP_SET_INDEX)
+}
+
+func (s Stringify) compile(compiler *Compiler) {
+	s.expression.compile(compiler)
+	compiler.emit(OP_STRINGIFY)
+}
+
+func (l Lambda) compile(compiler *Compiler) {
+	compiler.function(l.declaration, plainFunction)
+}
+
+func (s *Super) compile(compiler *Compiler) {
+	compiler.line = s.keyword.line
+	compiler.getVariable("this")
+	compiler.getVariable("super")
+	compiler.line = s.method.line
+	compiler.emitOp(OP_GET_SUPER, compiler.identifier(s.method.lexeme))
+}
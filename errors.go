@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LexError 词法分析错误
+type LexError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+// ParseError 语法分析及静态解析错误
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+// RuntimeError 解释执行时的运行时错误，可以被Lox代码中的try/catch捕获
+type RuntimeError struct {
+	Line    int
+	Message string
+	Module  string // 出错代码所在模块的路径，在入口模块中出错时为空
+	located bool   // 是否已经确定出错代码所在的模块
+	// 由throw语句抛出时保存被抛出的Lox值
+	thrown bool
+	value  Value
+}
+
+// ErrorObject 解释器产生的运行时错误被catch捕获后对应的Lox值，可以读取message和line属性
+type ErrorObject struct {
+	message string
+	line    int
+}
+
+// 转换为catch语句中绑定的Lox值
+func (e *RuntimeError) loxValue() Value {
+	if e.thrown {
+		return e.value
+	}
+	return objectValue(&ErrorObject{e.Message, e.Line})
+}
+
+func (e *ErrorObject) get(name Token) Value {
+	switch name.lexeme {
+	case "message":
+		return stringValue(e.message)
+	case "line":
+		return numberValue(float64(e.line))
+	}
+	runtimeError(name.line, "Undefined property '"+name.lexeme+"'.")
+	return nilValue
+}
+
+func (e *LexError) Error() string {
+	return fmt.Sprintf("[line %d] %s", e.Line, e.Message)
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("[line %d] %s", e.Line, e.Message)
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Module != "" {
+		return fmt.Sprintf("[line %d in %s] %s", e.Line, e.Module, e.Message)
+	}
+	return fmt.Sprintf("[line %d] %s", e.Line, e.Message)
+}
+
+// ErrorList 一次词法分析或语法分析中收集到的全部错误
+type ErrorList []error
+
+func (list ErrorList) Error() string {
+	messages := make([]string, len(list))
+	for i, err := range list {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (list ErrorList) Unwrap() []error {
+	return list
+}
+
+// 语法分析和解释执行时通过panic抛出错误，在阶段入口处由catch转换为返回值
+func parseError(line int, message string) {
+	panic(&ParseError{line, message})
+}
+
+func runtimeError(line int, message string) {
+	panic(&RuntimeError{Line: line, Message: message})
+}
+
+// throw语句抛出Lox值，重新抛出捕获到的运行时错误时保留原来的行号和错误信息
+func throw(line int, value Value) {
+	if e, ok := value.asObject().(*ErrorObject); ok {
+		panic(&RuntimeError{Line: e.line, Message: e.message})
+	}
+	panic(&RuntimeError{Line: line, Message: toString(value), thrown: true, value: value})
+}
+
+// 捕获当前阶段抛出的错误并写入err，其他panic继续向上传递
+func catch(err *error) {
+	r := recover()
+	switch e := r.(type) {
+	case nil:
+	case *ParseError:
+		*err = e
+	case *RuntimeError:
+		*err = e
+	default:
+		panic(r)
+	}
+}
@@ -2,16 +2,19 @@ package main
 
 import (
 	"fmt"
-	"reflect"
+	"math"
 )
 
 type (
 	Expr interface {
-		eval(interpreter *Interpreter) interface{}
+		eval(interpreter *Interpreter) Value
+		resolve(resolver *Resolver)
+		compile(compiler *Compiler)
+		optimize() Expr
 	}
 
 	Literal struct {
-		value interface{}
+		value Value
 	}
 
 	Unary struct {
@@ -30,12 +33,22 @@ type (
 	}
 
 	Variable struct {
-		name Token
+		name  Token
+		depth int // 由Resolver计算的作用域距离，-1表示全局变量
+		slot  int // 由Resolver分配的槽位
 	}
 
 	Assign struct {
 		name  Token
 		value Expr
+		depth int // 由Resolver计算的作用域距离，-1表示全局变量
+		slot  int // 由Resolver分配的槽位
+	}
+
+	// 后置自增自减，先读取变量原来的值再执行赋值
+	Postfix struct {
+		variable *Variable
+		assign   *Assign
 	}
 
 	Logical struct {
@@ -44,91 +57,196 @@ type (
 		right    Expr
 	}
 
+	Conditional struct {
+		condition  Expr
+		thenBranch Expr
+		elseBranch Expr
+	}
+
 	Call struct {
 		callee Expr
 		paren  Token
 		args   []Expr
 	}
+
+	Get struct {
+		object Expr
+		name   Token
+	}
+
+	Set struct {
+		object Expr
+		name   Token
+		value  Expr
+	}
+
+	This struct {
+		keyword Token
+		depth   int // 由Resolver计算的作用域距离
+		slot    int // 由Resolver分配的槽位
+	}
+
+	ListLiteral struct {
+		bracket  Token
+		elements []Expr
+	}
+
+	MapLiteral struct {
+		brace  Token
+		keys   []Expr
+		values []Expr
+	}
+
+	Index struct {
+		object  Expr
+		bracket Token
+		index   Expr
+	}
+
+	IndexSet struct {
+		object  Expr
+		bracket Token
+		index   Expr
+		value   Expr
+	}
+
+	// 字符串插值中嵌入的表达式，求值后转换为字符串
+	Stringify struct {
+		expression Expr
+	}
+
+	Lambda struct {
+		declaration *functionStmt
+	}
+
+	Super struct {
+		keyword Token
+		method  Token
+		depth   int // 由Resolver计算的作用域距离
+		slot    int // 由Resolver分配的槽位
+	}
 )
 
-func (l Literal) eval(interpreter *Interpreter) interface{} {
+func (l Literal) eval(interpreter *Interpreter) Value {
 	return l.value
 }
 
-func (u Unary) eval(interpreter *Interpreter) interface{} {
-	right := u.right.eval(interpreter)
-	switch u.operator.tokenType {
+func (u Unary) eval(interpreter *Interpreter) Value {
+	return unaryOp(u.operator, u.right.eval(interpreter))
+}
+
+// 一元运算，解释器和虚拟机共用
+func unaryOp(operator Token, right Value) Value {
+	switch operator.tokenType {
 	case MINUS:
-		return -(right.(float64))
+		checkNumbers(operator, right)
+		return numberValue(-right.asNumber())
 	case BANG:
-		return !isTrue(right)
+		return boolValue(!isTrue(right))
+	case TILDE:
+		return numberValue(float64(^toInteger(operator, right)))
 	default:
 		return right
 	}
 }
 
-func (b Binary) eval(interpreter *Interpreter) interface{} {
+func (b Binary) eval(interpreter *Interpreter) Value {
 	left := b.left.eval(interpreter)
 	right := b.right.eval(interpreter)
-	switch b.operator.tokenType {
+	return binaryOp(b.operator, left, right)
+}
+
+// 二元运算，解释器和虚拟机共用
+func binaryOp(operator Token, left Value, right Value) Value {
+	switch operator.tokenType {
 	case PLUS:
-		if reflect.TypeOf(left).Kind() == reflect.Float64 {
-			checkOperands(reflect.Float64, b.operator, right)
-			return left.(float64) + right.(float64)
-		} else {
-			checkOperands(reflect.String, b.operator, left, right)
-			return left.(string) + right.(string)
+		if left.isString() && right.isString() {
+			return stringValue(left.asString() + right.asString())
 		}
+		checkNumbers(operator, left, right)
+		return numberValue(left.asNumber() + right.asNumber())
 	case MINUS:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) - right.(float64)
+		checkNumbers(operator, left, right)
+		return numberValue(left.asNumber() - right.asNumber())
 	case STAR:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) * right.(float64)
+		checkNumbers(operator, left, right)
+		return numberValue(left.asNumber() * right.asNumber())
 	case SLASH:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) / right.(float64)
+		checkNumbers(operator, left, right)
+		return numberValue(left.asNumber() / right.asNumber())
+	case PERCENT:
+		checkNumbers(operator, left, right)
+		return numberValue(math.Mod(left.asNumber(), right.asNumber()))
+	case STAR_STAR:
+		checkNumbers(operator, left, right)
+		return numberValue(math.Pow(left.asNumber(), right.asNumber()))
+	case AMPERSAND:
+		return numberValue(float64(toInteger(operator, left) & toInteger(operator, right)))
+	case PIPE:
+		return numberValue(float64(toInteger(operator, left) | toInteger(operator, right)))
+	case CARET:
+		return numberValue(float64(toInteger(operator, left) ^ toInteger(operator, right)))
+	case LESS_LESS:
+		return numberValue(float64(toInteger(operator, left) << shiftCount(operator, right)))
+	case GREATER_GREATER:
+		return numberValue(float64(toInteger(operator, left) >> shiftCount(operator, right)))
 	case GREATER:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) > right.(float64)
+		checkNumbers(operator, left, right)
+		return boolValue(left.asNumber() > right.asNumber())
 	case GREATER_EQUAL:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) >= right.(float64)
+		checkNumbers(operator, left, right)
+		return boolValue(left.asNumber() >= right.asNumber())
 	case LESS:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) < right.(float64)
+		checkNumbers(operator, left, right)
+		return boolValue(left.asNumber() < right.asNumber())
 	case LESS_EQUAL:
-		checkOperands(reflect.Float64, b.operator, left, right)
-		return left.(float64) <= right.(float64)
+		checkNumbers(operator, left, right)
+		return boolValue(left.asNumber() <= right.asNumber())
 	case BANG_EQUAL:
-		return left != right
+		return boolValue(left != right)
 	case EQUAL_EQUAL:
-		return left == right
+		return boolValue(left == right)
 	}
-	return nil
+	return nilValue
 }
 
-func (g Grouping) eval(interpreter *Interpreter) interface{} {
+func (g Grouping) eval(interpreter *Interpreter) Value {
 	return g.expression.eval(interpreter)
 }
 
-func (v Variable) eval(interpreter *Interpreter) interface{} {
-	return interpreter.local.get(v.name)
+func (v *Variable) eval(interpreter *Interpreter) Value {
+	return interpreter.lookUp(v.name, v.depth, v.slot)
 }
 
-func (a Assign) eval(interpreter *Interpreter) interface{} {
+func (a *Assign) eval(interpreter *Interpreter) Value {
 	value := a.value.eval(interpreter)
-	interpreter.local.assign(a.name, value)
+	if a.depth >= 0 {
+		interpreter.local.assignAt(a.depth, a.slot, value)
+	} else {
+		interpreter.global.assign(a.name, value)
+	}
 	return value
 }
 
-func (l Logical) eval(interpreter *Interpreter) interface{} {
+func (p Postfix) eval(interpreter *Interpreter) Value {
+	old := p.variable.eval(interpreter)
+	p.assign.eval(interpreter)
+	return old
+}
+
+func (l Logical) eval(interpreter *Interpreter) Value {
 	left := l.left.eval(interpreter)
-	if l.operator.tokenType == OR {
+	switch l.operator.tokenType {
+	case OR:
 		if isTrue(left) {
 			return left
 		}
-	} else {
+	case QUESTION_QUESTION:
+		// 只有左侧为nil时才对右侧求值
+		if !left.isNil() {
+			return left
+		}
+	default:
 		if !isTrue(left) {
 			return left
 		}
@@ -136,22 +254,138 @@ func (l Logical) eval(interpreter *Interpreter) interface{} {
 	return l.right.eval(interpreter)
 }
 
-func (c Call) eval(interpreter *Interpreter) interface{} {
+func (c Conditional) eval(interpreter *Interpreter) Value {
+	if isTrue(c.condition.eval(interpreter)) {
+		return c.thenBranch.eval(interpreter)
+	}
+	return c.elseBranch.eval(interpreter)
+}
+
+func (c Call) eval(interpreter *Interpreter) Value {
+	fun, args := c.prepare(interpreter)
+	return fun.call(interpreter, c.paren, args)
+}
+
+// 对被调用对象和参数求值，并检查能否调用
+func (c Call) prepare(interpreter *Interpreter) (Callable, []Value) {
 	callee := c.callee.eval(interpreter)
 
-	args := make([]interface{}, len(c.args))
+	args := make([]Value, len(c.args))
 	for i, arg := range c.args {
 		args[i] = arg.eval(interpreter)
 	}
 
-	fun, ok := callee.(Function)
+	fun, ok := callee.asObject().(Callable)
+	if !ok {
+		runtimeError(c.paren.line, "Can only call functions and classes.")
+	}
+	// 参数个数为负数的可调用对象接受任意个数的参数
+	if arity := fun.arity(); arity >= 0 && arity != len(args) {
+		runtimeError(c.paren.line, fmt.Sprintf("Expect %d arguments but get %d", arity, len(args)))
+	}
+	return fun, args
+}
 
+func (g Get) eval(interpreter *Interpreter) Value {
+	object := g.object.eval(interpreter)
+	switch value := object.asObject().(type) {
+	case *Instance:
+		return value.get(g.name)
+	case *ErrorObject:
+		return value.get(g.name)
+	case *Module:
+		return value.get(g.name)
+	}
+	runtimeError(g.name.line, "Only instances have properties.")
+	return nilValue
+}
+
+func (s Set) eval(interpreter *Interpreter) Value {
+	object := s.object.eval(interpreter)
+	instance, ok := object.asObject().(*Instance)
 	if !ok {
-		exitWithErr(c.paren.line, "Can only call functions")
+		runtimeError(s.name.line, "Only instances have fields.")
 	}
-	if fun.arity() != len(args) {
-		exitWithErr(c.paren.line, fmt.Sprintf("Expect %d arguments but get %d", fun.arity(), len(args)))
+	value := s.value.eval(interpreter)
+	instance.set(s.name, value)
+	return value
+}
+
+func (t *This) eval(interpreter *Interpreter) Value {
+	return interpreter.lookUp(t.keyword, t.depth, t.slot)
+}
+
+func (l ListLiteral) eval(interpreter *Interpreter) Value {
+	elements := make([]Value, len(l.elements))
+	for i, element := range l.elements {
+		elements[i] = element.eval(interpreter)
 	}
+	return objectValue(&List{elements})
+}
 
-	return fun.call(interpreter, args)
+func (m MapLiteral) eval(interpreter *Interpreter) Value {
+	result := &Map{map[Value]Value{}}
+	for i, key := range m.keys {
+		result.set(m.brace, key.eval(interpreter), m.values[i].eval(interpreter))
+	}
+	return objectValue(result)
+}
+
+func (i Index) eval(interpreter *Interpreter) Value {
+	object := i.object.eval(interpreter)
+	index := i.index.eval(interpreter)
+	return indexGet(i.bracket, object, index)
+}
+
+// 下标访问，解释器和虚拟机共用
+func indexGet(bracket Token, object Value, index Value) Value {
+	if object.isString() {
+		// 字符串按Unicode字符下标访问，结果为单个字符组成的字符串
+		runes := []rune(object.asString())
+		return stringValue(string(runes[checkIndex(bracket, "String", index, len(runes))]))
+	}
+	switch container := object.asObject().(type) {
+	case *List:
+		return container.elements[container.index(bracket, index)]
+	case *Map:
+		return container.get(bracket, index)
+	}
+	runtimeError(bracket.line, "Only lists, maps and strings can be indexed.")
+	return nilValue
+}
+
+func (i IndexSet) eval(interpreter *Interpreter) Value {
+	object := i.object.eval(interpreter)
+	index := i.index.eval(interpreter)
+	switch container := object.asObject().(type) {
+	case *List:
+		value := i.value.eval(interpreter)
+		container.elements[container.index(i.bracket, index)] = value
+		return value
+	case *Map:
+		value := i.value.eval(interpreter)
+		container.set(i.bracket, index, value)
+		return value
+	}
+	runtimeError(i.bracket.line, "Only lists and maps support index assignment.")
+	return nilValue
+}
+
+func (s Stringify) eval(interpreter *Interpreter) Value {
+	return stringValue(toString(s.expression.eval(interpreter)))
+}
+
+func (l Lambda) eval(interpreter *Interpreter) Value {
+	return objectValue(&Function{l.declaration, interpreter.local, false, interpreter.module})
+}
+
+func (s *Super) eval(interpreter *Interpreter) Value {
+	superclass := interpreter.local.getAt(s.depth, s.slot).asObject().(*Class)
+	// this所在的作用域紧挨在super所在作用域的内层，并且是其中唯一的变量
+	instance := interpreter.local.getAt(s.depth-1, 0).asObject().(*Instance)
+	method, ok := superclass.findMethod(s.method.lexeme)
+	if !ok {
+		runtimeError(s.method.line, "Undefined property '"+s.method.lexeme+"'.")
+	}
+	return objectValue(method.bind(instance))
 }
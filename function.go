@@ -1,31 +1,57 @@
 package main
 
 type Function struct {
-	declaration functionStmt
+	declaration   *functionStmt
+	closure       *Environment // 函数定义时所在的局部作用域，定义在全局作用域时为nil
+	isInitializer bool         // 是否为类的初始化方法init
+	module        *Module      // 函数定义时所在的模块，函数体在该模块的全局变量表中执行
 }
 
-func (f *Function) call(interpreter *Interpreter, args []interface{}) interface{} {
-	functionLocal := &Table{
-		father: interpreter.local,
-		values: map[string]interface{}{},
-	}
-	for i := 0; i < f.arity(); i++ {
-		functionLocal.define(f.declaration.params[i].lexeme, args[i])
-	}
-	interpreter.enterScope(functionLocal)
-	defer interpreter.enterScope(functionLocal.father)
-	for _, stmt := range f.declaration.stmts {
-		depth := len(interpreter.returnStack)
-		stmt.exec(interpreter)
-		if depth < len(interpreter.returnStack) {
-			result := interpreter.returnStack[len(interpreter.returnStack)-1]
-			interpreter.returnStack = interpreter.returnStack[:len(interpreter.returnStack)-1]
+func (f *Function) call(interpreter *Interpreter, paren Token, args []Value) Value {
+	caller, callerModule, callerGlobals := interpreter.local, interpreter.module, interpreter.global
+	defer func() {
+		r := recover()
+		if r != nil {
+			interpreter.locate(r)
+		}
+		interpreter.enterScope(caller)
+		interpreter.module, interpreter.global = callerModule, callerGlobals
+		if r != nil {
+			panic(r)
+		}
+	}()
+	// 函数体以尾调用返回时，在同一层循环中继续执行被调用的函数
+	for {
+		// 参数依次占据函数作用域最前面的槽位
+		functionLocal := _Environment(f.closure, f.declaration.size, args...)
+		interpreter.enterScope(functionLocal)
+		interpreter.module, interpreter.global = f.module, f.module.globals
+		sig := interpreter.execAll(f.declaration.stmts)
+		if next := interpreter.tailCall; next.function != nil {
+			interpreter.tailCall = tailCall{}
+			f, args = next.function, next.args
+			continue
+		}
+		// 初始化方法总是返回实例本身
+		if f.isInitializer {
+			interpreter.returnValue = nilValue
+			return f.closure.slots[0]
+		}
+		if sig == sigReturn {
+			result := interpreter.returnValue
+			interpreter.returnValue = nilValue
 			return result
 		}
+		return nilValue
 	}
-	return nil
 }
 
 func (f *Function) arity() int {
 	return len(f.declaration.params)
 }
+
+// 将方法绑定到实例上，方法体内的this指向该实例
+func (f *Function) bind(instance *Instance) *Function {
+	env := _Environment(f.closure, 1, objectValue(instance))
+	return &Function{f.declaration, env, f.isInitializer, f.module}
+}
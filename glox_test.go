@@ -1,12 +1,21 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 )
 
 func TestLexer(t *testing.T) {
 	s := "print \"Hello, world!\";"
-	tokens := _Lexer(s).lex()
+	tokens, err := _Lexer(s).lex()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
 	var expect = []string{"print", "\"Hello, world!\"", ";", "$EOF"}
 	if len(expect) != len(tokens) {
 		t.Errorf("Expected %d tokens but get %d.\n", len(expect), len(tokens))
@@ -28,15 +37,18 @@ func TestParser(t *testing.T) {
 	tokens = append(tokens, _Token(STRING, "hello", "hello", 2))
 	tokens = append(tokens, _Token(SEMICOLON, ";", nil, 2))
 	tokens = append(tokens, _Token(EOF, "$EOF", nil, 2))
-	stmts := _Parser(tokens).parse()
+	stmts, err := _Parser(tokens).parse()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
 	if len(stmts) != 1 {
 		t.Errorf("Expected 1 statement.\n")
 	}
 	stmt := stmts[0].(ifStmt)
-	if stmt.condition.(Literal).value != true {
+	if stmt.condition.(Literal).value != boolValue(true) {
 		t.Errorf("Expected condition equals true.\n")
 	}
-	if stmt.thenBranch.(printStmt).expr.(Literal).value != "hello" {
+	if stmt.thenBranch.(printStmt).expr.(Literal).value != stringValue("hello") {
 		t.Errorf("Expected the expr of thenBranch equals \"hello\".\n")
 	}
 	if stmt.elseBranch != nil {
@@ -46,8 +58,8 @@ func TestParser(t *testing.T) {
 
 func TestInterpreter(t *testing.T) {
 	stmt := ifStmt{
-		condition:  Literal{value: true},
-		thenBranch: printStmt{expr: Literal{value: "hello"}},
+		condition:  Literal{value: boolValue(true)},
+		thenBranch: printStmt{expr: Literal{value: stringValue("hello")}},
 		elseBranch: nil,
 	}
 	stmt.exec(_Interpreter())
@@ -55,3 +67,1192 @@ func TestInterpreter(t *testing.T) {
 		t.Errorf("Expected \"hello\" in buffer.\n")
 	}
 }
+
+// 执行一段源代码并返回输出结果
+func output(code string) string {
+	_ = Play(code)
+	return Buf.String()
+}
+
+// 检查err能否转换为target指向的错误类型，且行号和错误信息与预期一致
+func expectError(t *testing.T, err error, line int, message string, target interface{}) {
+	t.Helper()
+	expect := fmt.Sprintf("[line %d] %s", line, message)
+	if err == nil || !errors.As(err, target) {
+		t.Errorf("Expected error %q of type %T but get %v.\n", expect, target, err)
+		return
+	}
+	if err.Error() != expect {
+		t.Errorf("Expected error %q but get %q.\n", expect, err.Error())
+	}
+}
+
+func TestClosure(t *testing.T) {
+	code := `
+fun makeCounter() {
+  var i = 0;
+  fun count() {
+    i = i + 1;
+    return i;
+  }
+  return count;
+}
+var counter = makeCounter();
+print counter();
+print counter();
+
+var a = "global";
+fun show() { print a; }
+fun caller() {
+  var a = "local";
+  show();
+}
+caller();
+`
+	if got := output(code); got != "1\n2\nglobal\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestReturnUnwinding(t *testing.T) {
+	code := `
+fun f() { while (true) { return 1; } }
+fun g() {
+  for (var i = 0; i < 10; i = i + 1) {
+    if (i == 3) { { return i; } }
+    print i;
+  }
+  return -1;
+}
+fun h() { { return "block"; } return "after"; }
+print f();
+print g();
+print h();
+`
+	if got := output(code); got != "1\n0\n1\n2\n3\nblock\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	code := `
+for (var i = 0; i < 10; i = i + 1) {
+  if (i == 1) continue;
+  if (i == 4) break;
+  print i;
+}
+var j = 0;
+while (true) {
+  j = j + 1;
+  if (j < 3) { continue; }
+  print j;
+  break;
+}
+`
+	if got := output(code); got != "0\n2\n3\n3\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	var parseErr *ParseError
+	cases := []struct {
+		code    string
+		line    int
+		message string
+	}{
+		{"break;", 1, "Can't use 'break' outside of a loop."},
+		{"if (true) {\n  continue;\n}", 2, "Can't use 'continue' outside of a loop."},
+		{"while (true) {\n  fun f() { break; }\n}", 2, "Can't use 'break' outside of a loop."},
+		{"for (;;) {\n  fun f() {\n    continue;\n  }\n}", 3, "Can't use 'continue' outside of a loop."},
+	}
+	for _, c := range cases {
+		expectError(t, Play(c.code), c.line, c.message, &parseErr)
+	}
+}
+
+func TestClass(t *testing.T) {
+	code := `
+class Point {
+  init(x, y) {
+    this.x = x;
+    this.y = y;
+  }
+  sum() { return this.x + this.y; }
+  scale(k) {
+    this.x = this.x * k;
+    this.y = this.y * k;
+    return this;
+  }
+}
+var p = Point(1, 2);
+print p.sum();
+print p.scale(10).sum();
+var sum = p.sum;
+p.x = 0;
+print sum();
+print Point;
+print p;
+`
+	if got := output(code); got != "3\n30\n20\n<class $Point>\n<instance $Point>\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	code := `
+class A {
+  init(name) { this.name = name; }
+  hello() { return "A:" + this.name; }
+  only() { return "only A"; }
+}
+class B < A {
+  init(name) { super.init(name + "!"); }
+  hello() { return "B>" + super.hello(); }
+}
+class C < B {}
+var c = C("c");
+print c.hello();
+print c.only();
+`
+	if got := output(code); got != "B>A:c!\nonly A\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	var parseErr *ParseError
+	var runtimeErr *RuntimeError
+	cases := []struct {
+		code    string
+		line    int
+		message string
+		target  interface{}
+	}{
+		{"class A {}\nclass B < B {}", 2, "A class can't inherit from itself.", &parseErr},
+		{"var NotClass = 1;\n\nclass B < NotClass {}", 3, "Superclass must be a class.", &runtimeErr},
+	}
+	for _, c := range cases {
+		expectError(t, Play(c.code), c.line, c.message, c.target)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	code := `
+var a = "global";
+{
+  fun show() { print a; }
+  show();
+  var a = "block";
+  show();
+  print a;
+}
+`
+	if got := output(code); got != "global\nglobal\nblock\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	tokens, _ := _Lexer("{ var b = 1; { print b; } }").lex()
+	stmts, _ := _Parser(tokens).parse()
+	if err := _Resolver().resolveAll(stmts); err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
+	inner := stmts[0].(*blockStmt).stmts[1].(*blockStmt).stmts[0].(printStmt)
+	if depth := inner.expr.(*Variable).depth; depth != 1 {
+		t.Errorf("Expected variable resolved at depth 1 but get %d.\n", depth)
+	}
+
+	var parseErr *ParseError
+	cases := []struct {
+		code    string
+		line    int
+		message string
+	}{
+		{"{\n  var a = 1;\n  var a = 2;\n}", 3, "Already a variable with this name in this scope."},
+		{"fun f(a, a) {}", 1, "Already a variable with this name in this scope."},
+		{"var a = 1;\n{\n  var a = a;\n}", 3, "Can't read local variable in its own initializer."},
+	}
+	for _, c := range cases {
+		expectError(t, Play(c.code), c.line, c.message, &parseErr)
+	}
+}
+
+func TestNative(t *testing.T) {
+	code := `
+var start = clock();
+print clock() >= start;
+print str("a", 1, true, nil);
+print str();
+print clock;
+`
+	if got := output(code); got != "true\na1truenil\n\n<native fun $clock>\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var lexErr *LexError
+	var parseErr *ParseError
+	var runtimeErr *RuntimeError
+	cases := []struct {
+		code    string
+		line    int
+		message string
+		target  interface{}
+	}{
+		{"print \"abc;", 1, "Unterminated string.", &lexErr},
+		{"var a = 1;\nprint a", 2, "Expect ';' after value.", &parseErr},
+		{"return 1;", 1, "Can't return from top-level code.", &parseErr},
+		{"print 1;\nprint 1 + \"a\";", 2, "Operator '+' expect right operands.", &runtimeErr},
+		{"print b;", 1, "Undefined variable 'b'.", &runtimeErr},
+	}
+	for _, c := range cases {
+		expectError(t, Play(c.code), c.line, c.message, c.target)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	_, err := _Lexer("var a = @;\nvar b = \"ok\";\nprint a @ b;").lex()
+	if err == nil || err.Error() != "[line 1] Unexpected character.\n[line 3] Unexpected character." {
+		t.Errorf("Unexpected lex errors: %v.\n", err)
+	}
+
+	code := `
+var a = ;
+print "fine";
+fun f( { }
+var c = 2 var d = 3;
+print 1 +;
+`
+	expect := "[line 2] Unexpected ';' at here.\n" +
+		"[line 4] Expect parameter name.\n" +
+		"[line 5] Expect ';' after variable declaration.\n" +
+		"[line 6] Unexpected ';' at here."
+	tokens, _ := _Lexer(code).lex()
+	if _, err := _Parser(tokens).parse(); err == nil || err.Error() != expect {
+		t.Errorf("Unexpected parse errors: %v.\n", err)
+	}
+}
+
+func TestLambda(t *testing.T) {
+	code := `
+fun apply(f, x) { return f(x); }
+print apply(fun (n) { return n * 2; }, 21);
+var add = fun (a, b) { return a + b; };
+print add(1, 2);
+print add;
+fun () { print "now"; }();
+`
+	if got := output(code); got != "42\n3\n<anonymous fun>\nnow\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestList(t *testing.T) {
+	code := `
+var xs = [1, "two", [3], nil];
+print xs;
+print xs[1];
+xs[0] = xs[0] + 10;
+print xs[0];
+push(xs, true);
+print len(xs);
+var ys = xs;
+print ys == xs;
+print [] == [];
+print len([]);
+`
+	if got := output(code); got != "[1, \"two\", [3], nil]\ntwo\n11\n5\ntrue\nfalse\n0\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	// 包含自身的列表输出为[...]，同一列表出现多次但不成环时照常输出
+	code = `
+var l = [1];
+push(l, l);
+print l;
+print str(l);
+print "${l}";
+var shared = [2];
+print [shared, shared];
+`
+	expect := "[1, [...]]\n[1, [...]]\n[1, [...]]\n[[2], [2]]\n"
+	for _, opts := range []options{{}, {vm: true}} {
+		if got := outputWith(code, opts); got != expect {
+			t.Errorf("Unexpected output %q with options %+v.\n", got, opts)
+		}
+	}
+
+	var runtimeErr *RuntimeError
+	cases := []struct {
+		code    string
+		line    int
+		message string
+	}{
+		{"var xs = [1];\nprint xs[1];", 2, "List index out of range."},
+		{"var xs = [1];\nprint xs[0.5];", 2, "List index must be an integer."},
+		{"var xs = [1];\nxs[-1] = 2;", 2, "List index out of range."},
+		{"print 1[0];", 1, "Only lists, maps and strings can be indexed."},
+	}
+	for _, c := range cases {
+		expectError(t, Play(c.code), c.line, c.message, &runtimeErr)
+	}
+}
+
+func TestMap(t *testing.T) {
+	code := `
+var m = {"b": 2, "a": 1, 3: "three", true: nil, nil: [1]};
+m["c"] = 3;
+print m["a"] + m["c"];
+print m["missing"];
+print m;
+print keys({"y": 1, "x": 2});
+print len(m);
+print {};
+`
+	expect := "4\nnil\n{nil: [1], true: nil, 3: \"three\", \"a\": 1, \"b\": 2, \"c\": 3}\n[\"x\", \"y\"]\n6\n{}\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+	if err := Play("var m = {};\nm[[1]] = 1;"); err == nil || err.Error() != "[line 2] Unhashable map key '[1]'." {
+		t.Errorf("Unexpected error: %v.\n", err)
+	}
+	if err := Play("var m = {};\nm[0/0] = 1;"); err == nil || err.Error() != "[line 2] Unhashable map key 'NaN'." {
+		t.Errorf("Unexpected error: %v.\n", err)
+	}
+	if err := Play("print {0/0: 1};"); err == nil || err.Error() != "[line 1] Unhashable map key 'NaN'." {
+		t.Errorf("Unexpected error: %v.\n", err)
+	}
+
+	// 包含自身的字典输出为{...}，与列表互相包含时同样停止展开
+	code = `
+var m = {};
+m["s"] = m;
+print m;
+var l = [m];
+m["l"] = l;
+print l;
+print str(m);
+`
+	expect = "{\"s\": {...}}\n[{\"l\": [...], \"s\": {...}}]\n{\"l\": [{...}], \"s\": {...}}\n"
+	for _, opts := range []options{{}, {vm: true}} {
+		if got := outputWith(code, opts); got != expect {
+			t.Errorf("Unexpected output %q with options %+v.\n", got, opts)
+		}
+	}
+}
+
+func TestStringEscape(t *testing.T) {
+	code := `
+print "a\tb\\c \"q\" \u00e9\u{1F600} \${x}";
+var name = "glox";
+var n = 2;
+print "Hello ${name}!";
+print "${n} + ${n} = ${n + n}, ${[1, {"k": "v"}]}";
+print "nested ${"inner ${name}"} done";
+`
+	expect := "a\tb\\c \"q\" é😀 ${x}\nHello glox!\n2 + 2 = 4, [1, {\"k\": \"v\"}]\nnested inner glox done\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	_, err := _Lexer("print \"\\q\";\nprint \"\\u{zz}\";\nprint \"\\u41\" + \"\\u4Z\";\nprint \"${1\";").lex()
+	expectErr := "[line 1] Invalid escape sequence '\\q'.\n" +
+		"[line 2] Invalid unicode escape sequence '\\uzz'.\n" +
+		"[line 3] Invalid unicode escape sequence '\\u41'.\n" +
+		"[line 3] Invalid unicode escape sequence '\\u4'.\n" +
+		"[line 4] Unterminated string.\n" +
+		"[line 4] Unterminated string interpolation."
+	if err == nil || err.Error() != expectErr {
+		t.Errorf("Unexpected lex errors: %v.\n", err)
+	}
+
+	var parseErr *ParseError
+	expectError(t, Play(`print "a${}b";`), 1, "Expect expression in string interpolation.", &parseErr)
+	expectError(t, Play(`print "${1}${}";`), 1, "Expect expression in string interpolation.", &parseErr)
+	if got := output(`print "${"}"}";`); got != "}\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	code := "\uFEFF// 中文注释\nvar 名字 = \"世界\";\nvar café2 = 1;\nprint \"你好，\" + 名字;\nprint len(名字);\nprint 名字[1];\nprint café2;\n"
+	if got := output(code); got != "你好，世界\n2\n界\n1\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	tokens, _ := _Lexer("var 变量 = 1;").lex()
+	columns := []int{1, 5, 8, 10, 11}
+	for i, column := range columns {
+		if tokens[i].column != column {
+			t.Errorf("Expected token %q at column %d but get %d.\n", tokens[i].lexeme, column, tokens[i].column)
+		}
+	}
+}
+
+func TestNumberLiteral(t *testing.T) {
+	code := "print 0xFF;\nprint 0b1010;\nprint 0o17;\nprint 1e9;\nprint 2.5E-3;\nprint 1_000_000;\nprint 0xdead_BEEF;\nprint 1.5e+2;\n"
+	if got := output(code); got != "255\n10\n15\n1e+09\n0.0025\n1e+06\n3.735928559e+09\n150\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	var lexErr *LexError
+	cases := map[string]string{
+		"0x":     "Expect hexadecimal digits after '0x'.",
+		"0b102":  "Invalid digit '2' in binary number '0b102'.",
+		"0o8":    "Invalid digit '8' in octal number '0o8'.",
+		"0x_1":   "Expect hexadecimal digits after '0x'.",
+		"1e":     "Expect digits in exponent of number '1e'.",
+		"2.5e-":  "Expect digits in exponent of number '2.5e-'.",
+		"1__000": "Digit separator '_' must be between digits in number '1__000'.",
+		"1_.5e1": "Digit separator '_' must be between digits in number '1_.5e1'.",
+		"0b1__1": "Digit separator '_' must be between digits in number '0b1__1'.",
+		"100_":   "Digit separator '_' must be between digits in number '100_'.",
+		"1e999":  "Number '1e999' is out of range.",
+	}
+	for source, message := range cases {
+		_, err := _Lexer(source).lex()
+		expectError(t, err, 1, message, &lexErr)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	code := `
+print 7 % 3;
+print -7 % 3;
+print 2 ** 10;
+print 2 ** 3 ** 2;
+print -2 ** 2;
+print 2 ** -1;
+print 6 & 3;
+print 6 | 3;
+print 6 ^ 3;
+print ~5;
+print 1 << 4;
+print -16 >> 2;
+print 1 | 2 == 3;
+print 1 + 2 << 1;
+`
+	expect := "1\n-1\n1024\n512\n-4\n0.5\n2\n7\n5\n-6\n16\n-4\ntrue\n6\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	var runtimeErr *RuntimeError
+	cases := map[string]string{
+		"print 1.5 & 1;": "Operator '&' expect integer operands.",
+		"print ~\"a\";":  "Operator '~' expect integer operands.",
+		"print 1 << -1;": "Operator '<<' expect non-negative shift count.",
+		"print 1 % nil;": "Operator '%' expect right operands.",
+	}
+	for code, message := range cases {
+		expectError(t, Play(code), 1, message, &runtimeErr)
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	code := `
+var a = 10;
+a += 5;
+a -= 3;
+a *= 2;
+a /= 4;
+print a;
+var s = "a";
+s += "b";
+print s;
+var i = 0;
+print i++;
+print i;
+print ++i;
+print i--;
+print --i;
+for (var j = 0; j < 3; j++) { print j; }
+`
+	if got := output(code); got != "6\nab\n0\n1\n2\n2\n0\n0\n1\n2\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	cases := []string{"1 += 2;", "var a; a.b++;", "var a; ++a[0];"}
+	for _, code := range cases {
+		if err := Play(code); err == nil || err.Error() != "[line 1] Invalid assignment target." {
+			t.Errorf("Expected invalid assignment target error for %q but get %v.\n", code, err)
+		}
+	}
+}
+
+func TestConditional(t *testing.T) {
+	code := `
+fun loud(x) { print "eval " + x; return x; }
+print true ? loud("a") : loud("b");
+print nil ? 1 : false ? 2 : 3;
+var name;
+print name ?? "default";
+print false ?? loud("skipped");
+print nil ?? nil ?? "last";
+var x = 1 > 2 ? "big" : "small";
+print x;
+`
+	expect := "eval a\na\n3\ndefault\nfalse\nlast\nsmall\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	code := `
+try {
+  print undefined;
+} catch (e) {
+  print e.message;
+  print e.line;
+}
+fun risky(n) {
+  if (n > 1) throw "too big: ${n}";
+  return n;
+}
+try {
+  print risky(1);
+  print risky(2);
+  print "unreachable";
+} catch (e) {
+  print e;
+} finally {
+  print "finally";
+}
+fun f() {
+  try {
+    return "try";
+  } finally {
+    print "cleanup";
+  }
+}
+print f();
+for (var i = 0; i < 3; i++) {
+  try {
+    if (i == 1) continue;
+    print i;
+  } finally {
+    print "after " + str(i);
+  }
+}
+try {
+  try { 1 + nil; } catch (e) { throw e; }
+} catch (outer) {
+  print outer;
+}
+`
+	expect := "Undefined variable 'undefined'.\n3\n1\ntoo big: 2\nfinally\ncleanup\ntry\n" +
+		"0\nafter 0\nafter 1\n2\nafter 2\n[line 38] Operator '+' expect right operands.\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	err := Play("try {\n  throw \"boom\";\n} finally {\n  print \"done\";\n}")
+	if err == nil || err.Error() != "[line 2] boom" || Buf.String() != "done\n[line 2] boom\n" {
+		t.Errorf("Unexpected uncaught error %v with output %q.\n", err, Buf.String())
+	}
+
+	// finally中的函数调用不能覆盖try或catch中尚未返回的值
+	code = `
+fun g() { return "g"; }
+fun f() {
+  try { return "try"; } finally { g(); }
+}
+fun h() {
+  try { nil + 1; } catch (e) { return "catch"; } finally { var x = g(); }
+}
+fun loop() {
+  for (var i = 0; i < 3; i = i + 1) {
+    try { if (i == 1) return i; } finally { var x = g(); }
+  }
+}
+print f();
+print h();
+print loop();
+`
+	if got := output(code); got != "try\ncatch\n1\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	files := fstest.MapFS{
+		"lib/math.lox": {Data: []byte(`
+import "counter.lox";
+print "loading math";
+var pi = 3;
+fun square(x) { return x * x; }
+fun area(r) { return pi * square(r); }
+`)},
+		"lib/counter.lox": {Data: []byte(`
+var count = 0;
+fun next() { count = count + 1; return count; }
+`)},
+		"cycle/a.lox":  {Data: []byte(`import "b.lox";`)},
+		"cycle/b.lox":  {Data: []byte(`import "a.lox";`)},
+		"hijack.lox":   {Data: []byte(`len = "hijacked"; print len;`)},
+		"app/main.lox": {Data: []byte(`import "../lib/counter.lox" as c; print c.next();`)},
+	}
+	code := `
+import "lib/math.lox";
+import "lib/math.lox" as m;
+var pi = 100;
+print area(2);
+print m.square(3);
+print m.pi;
+print m;
+import "lib/counter.lox" as c;
+print c.next();
+print c.next();
+print c.count;
+`
+	if err := PlayFS(files, code); err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
+	if got := Buf.String(); got != "loading math\n12\n9\n3\n<module $lib/math.lox>\n1\n2\n2\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	// 给原生函数赋值只影响当前模块，不修改其他模块共享的原生函数
+	code = `
+import "hijack.lox" as h;
+print len;
+print h.len;
+print len([1]);
+`
+	if err := PlayFS(files, code); err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
+	if got := Buf.String(); got != "hijacked\n<native fun $len>\nhijacked\n1\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	// 入口模块不在根目录时，"../"开头的路径相对于入口模块所在目录解析
+	entry, _ := files.ReadFile("app/main.lox")
+	if got := outputIn(files, "app/main.lox", string(entry), options{}); got != "1\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	var parseErr *ParseError
+	var runtimeErr *RuntimeError
+	cases := []struct {
+		code    string
+		message string
+		target  interface{}
+	}{
+		{`import "missing.lox";`, "Can't read module 'missing.lox'.", &runtimeErr},
+		{"fun f() { import \"x.lox\"; }", "Can't import outside of top-level code.", &parseErr},
+	}
+	for _, c := range cases {
+		expectError(t, PlayFS(files, c.code), 1, c.message, c.target)
+	}
+	expectError(t, Play(`import "lib/math.lox";`), 1, "Can't import modules without a file system.", &runtimeErr)
+
+	// 在导入的模块中出错时错误信息包含模块路径，模块回调入口模块中的函数出错时不包含
+	files["lib/fail.lox"] = &fstest.MapFile{Data: []byte("fun boom() {\n  return nil + 1;\n}\nfun apply(f) { return f(); }\n")}
+	files["lib/broken.lox"] = &fstest.MapFile{Data: []byte("var ok = 1;\nprint missing;\n")}
+	located := map[string]string{
+		`import "cycle/a.lox";`:                                                    "[line 1 in cycle/b.lox] Cyclic import of module 'cycle/a.lox'.",
+		"import \"lib/fail.lox\" as f;\nf.boom();":                                 "[line 2 in lib/fail.lox] Operator '+' expect right operands.",
+		`import "lib/broken.lox";`:                                                 "[line 2 in lib/broken.lox] Undefined variable 'missing'.",
+		"import \"lib/fail.lox\" as f;\nf.nothing;":                                "[line 2] Undefined name 'nothing' in module 'lib/fail.lox'.",
+		"import \"lib/fail.lox\" as f;\nfun bad() { return -nil; }\nf.apply(bad);": "[line 2] Operator '-' expect right operands.",
+	}
+	for code, expect := range located {
+		if err := PlayFS(files, code); !errors.As(err, &runtimeErr) || err.Error() != expect {
+			t.Errorf("Expected error %q but get %v.\n", expect, err)
+		}
+	}
+
+	// 字节码虚拟机导入模块的输出和错误信息与解释器一致
+	programs := []string{
+		"import \"lib/math.lox\" as m;\nimport \"lib/counter.lox\";\nprint m.area(2);\nprint next();\nprint count;\nprint m;",
+		`import "hijack.lox" as h; print len; print h.len;`,
+		`import "missing.lox";`,
+	}
+	for code := range located {
+		programs = append(programs, code)
+	}
+	for _, code := range programs {
+		expect := outputIn(files, "", code, options{})
+		if got := outputIn(files, "", code, options{vm: true}); got != expect {
+			t.Errorf("VM output %q differs from interpreter output %q for:\n%s\n", got, expect, code)
+		}
+	}
+	if got := outputIn(files, "app/main.lox", string(entry), options{vm: true}); got != "1\n" {
+		t.Errorf("Unexpected VM output: %q.\n", got)
+	}
+}
+
+// 使用指定的选项执行一段源代码并返回输出结果
+func outputWith(code string, opts options) string {
+	return outputIn(nil, "", code, opts)
+}
+
+// 将一段源代码作为files中名为name的入口模块执行并返回输出结果
+func outputIn(files fs.FS, name string, code string, opts options) string {
+	Buf.Reset()
+	if err := run(files, name, code, opts); err != nil {
+		Buf.WriteString(err.Error() + "\n")
+	}
+	return Buf.String()
+}
+
+// 读取test_case目录下的所有源代码
+func testCases(t *testing.T) []string {
+	files, err := filepath.Glob("test_case/*.glox")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Failed to list test cases: %v.\n", err)
+	}
+	var codes []string
+	for _, file := range files {
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v.\n", file, err)
+		}
+		codes = append(codes, string(bts))
+	}
+	return codes
+}
+
+func TestVM(t *testing.T) {
+	snippets := []string{`
+fun makeCounter() {
+  var i = 0;
+  fun count() { i++; return i; }
+  return count;
+}
+var c = makeCounter();
+c(); c();
+print c();
+var fs = [];
+for (var i = 0; i < 3; i++) {
+  var j = i;
+  push(fs, fun () { return j * 10; });
+}
+print fs[0]() + fs[1]() + fs[2]();
+`, `
+class A {
+  init(name) { this.name = name; }
+  hello() { return "A " + this.name; }
+}
+class B < A {
+  init(name) { super.init(name); this.extra = 1; return; }
+  hello() { return "B " + super.hello(); }
+}
+var b = B("x");
+print b.hello();
+print b.extra;
+print B;
+print b;
+print b.hello;
+print fun () {};
+print clock;
+`, `
+var sum = 0;
+for (var i = 0; i < 10; i++) {
+  { var skip = i % 2 == 0; if (skip) continue; }
+  if (i > 7) break;
+  sum += i;
+}
+print sum;
+var n = nil;
+print n ?? "default";
+print sum > 10 ? "big" : "small";
+var l = [1, "two", [3]];
+l[0] = l[0] + 1;
+var m = {"a": 1, 2: true};
+m["b"] = nil;
+print l;
+print m;
+print len(l) + len(m);
+print "interpolated ${l[0] ** 3} and ${m["a"]}";
+print str(7 & 3 | 8, " ", ~0, " ", 1 << 4, " ", -(2));
+`, `
+fun attempt(x) {
+  var local = "kept";
+  try {
+    if (x == 0) return "body";
+    if (x == 1) throw "thrown";
+    nil();
+  } catch (e) {
+    print e;
+    if (x == 1) return "catch";
+    throw e;
+  } finally {
+    print "finally " + local;
+  }
+}
+print attempt(0);
+print attempt(1);
+try { attempt(2); } catch (e) { print e.message + " at " + str(e.line); }
+fun override() { try { return 1; } finally { return 2; } }
+print override();
+var captured;
+for (var i = 0; i < 5; i++) {
+  try {
+    var j = i;
+    captured = fun () { return j; };
+    if (i == 1) continue;
+    if (i == 3) break;
+    print i;
+  } finally {
+    print "leave " + str(i);
+  }
+}
+print captured();
+fun deep(n) { return n == 0 ? [][0] : deep(n - 1); }
+try { deep(50); } catch (e) { print e.message; }
+try { try { throw {"k": 1}; } finally { print "inner"; } } catch (e) { print e["k"]; }
+`}
+	// 覆盖各类语法的代码片段在两种后端上都不能出错，否则只是在比较相同的错误信息
+	for _, code := range snippets {
+		for _, opts := range []options{{}, {vm: true}} {
+			if err := run(nil, "", code, opts); err != nil {
+				t.Errorf("Unexpected error %v with options %+v for:\n%s\n", err, opts, code)
+			}
+		}
+	}
+
+	codes := append(testCases(t), snippets...)
+	codes = append(codes,
+		"print 1 + \"a\";",
+		"print undefined;",
+		"fun f(a) {}\nf(1, 2);",
+		"var x = 1;\nx();",
+		"class A {}\nA().missing;",
+		"var nope = 1;\nclass B < nope {}",
+		"var l = [1];\nprint l[3];",
+		"print len(1);",
+		"throw \"up\";",
+		"try {\n  print nil + 1;\n} finally {\n  print 1;\n}",
+		"try {\n  throw 1;\n} catch (e) {\n  print e.message;\n}",
+	)
+	for _, code := range codes {
+		expect := output(code)
+		if got := outputWith(code, options{vm: true}); got != expect {
+			t.Errorf("VM output %q differs from interpreter output %q for:\n%s\n", got, expect, code)
+		}
+	}
+
+	cases := map[string]string{
+		"fun f() {\n  return f();\n}\nf();": "[line 2] Stack overflow.\n",
+	}
+	for code, expect := range cases {
+		if got := outputWith(code, options{vm: true}); got != expect {
+			t.Errorf("Expected output %q but get %q.\n", expect, got)
+		}
+	}
+}
+
+const fibCode = `
+fun fib(n) {
+  if (n <= 1) return n;
+  return fib(n - 2) + fib(n - 1);
+}
+var result = fib(20);
+`
+
+func BenchmarkInterpreterFib(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := run(nil, "", fibCode, options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVMFib(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := run(nil, "", fibCode, options{vm: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	cases := map[string]string{
+		`print -"x";`:          "[line 1] Operator '-' expect right operands.",
+		"print -nil;":          "[line 1] Operator '-' expect right operands.",
+		"print nil + 1;":       "[line 1] Operator '+' expect right operands.",
+		`print "a" + 1;`:       "[line 1] Operator '+' expect right operands.",
+		`print "a" < "b";`:     "[line 1] Operator '<' expect right operands.",
+		"print true * 2;":      "[line 1] Operator '*' expect right operands.",
+		`print ~"x";`:          "[line 1] Operator '~' expect integer operands.",
+		"print [1][true];":     "[line 1] List index must be an integer.",
+		`print {}[[]];`:        "[line 1] Unhashable map key '[]'.",
+		"var f = 1;\nf.x = 2;": "[line 2] Only instances have fields.",
+	}
+	for code, expect := range cases {
+		for _, opts := range []options{{}, {vm: true}} {
+			err := run(nil, "", code, opts)
+			if _, ok := err.(*RuntimeError); !ok || err.Error() != expect {
+				t.Errorf("Expected runtime error %q but get %v with %+v.\n", expect, err, opts)
+			}
+		}
+	}
+	if got := output(`print 1 == true; print nil == false; print "1" == 1; print 0 / 0 == 0 / 0;`); got != "false\nfalse\nfalse\nfalse\n" {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+}
+
+const arithmeticCode = `
+var sum = 0;
+for (var i = 0; i < 20000; i++) {
+  sum = sum + i * 2 - i / 2;
+  if (sum > 1000000) sum = sum % 1000;
+}
+var s = "";
+for (var i = 0; i < 200; i++) s = s + "x";
+`
+
+func BenchmarkInterpreterArithmetic(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := run(nil, "", arithmeticCode, options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVMArithmetic(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := run(nil, "", arithmeticCode, options{vm: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+	code := `
+var a = "global";
+{
+  var a = "outer";
+  {
+    var b = "inner";
+    var a = "shadow";
+    print a + " " + b;
+  }
+  fun show() { print a; }
+  show();
+}
+fun counter(start) {
+  var count = start;
+  fun inc(step) {
+    var next = count + step;
+    count = next;
+    return count;
+  }
+  return inc;
+}
+var c = counter(10);
+c(1);
+print c(2);
+fun safe(f) {
+  var before = "before";
+  try {
+    var inside = f();
+    return inside;
+  } catch (e) {
+    var after = "caught " + e;
+    return before + " " + after;
+  }
+}
+print safe(fun () { throw "x"; });
+print safe(fun () { return "ok"; });
+fun outer() {
+  class A {
+    init(n) { this.n = n; }
+    get() { return this.n; }
+  }
+  class B < A {
+    get() {
+      var base = super.get();
+      return fun () { return base * 2; };
+    }
+  }
+  return B(21).get()();
+}
+print outer();
+var fs = [];
+for (var i = 0; i < 3; i++) {
+  var j = i;
+  push(fs, fun () { return j; });
+}
+print str(fs[0](), fs[1](), fs[2]());
+print a;
+`
+	expect := "shadow inner\nouter\n13\nbefore caught x\nok\n42\n012\nglobal\n"
+	if got := output(code); got != expect {
+		t.Errorf("Unexpected output: %q.\n", got)
+	}
+
+	// 闭包在局部变量定义完成前读写其槽位
+	code = `
+{ var g = fun () { return g; }(); print g; }
+fun f() {
+  var h = fun () { h = "assigned"; return "early"; }();
+  var after = "after";
+  print h + " " + after;
+}
+f();
+`
+	for _, opts := range []options{{}, {optimize: true}} {
+		if got := outputWith(code, opts); got != "nil\nearly after\n" {
+			t.Errorf("Unexpected output %q with options %+v.\n", got, opts)
+		}
+	}
+}
+
+const loopCode = `
+fun work(n) {
+  var total = 0;
+  for (var i = 0; i < n; i++) {
+    var square = i * i;
+    {
+      var half = square / 2;
+      total = total + half;
+    }
+  }
+  return total;
+}
+var result = work(20000);
+`
+
+func BenchmarkInterpreterLoop(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := run(nil, "", loopCode, options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestOptimizer(t *testing.T) {
+	codes := append(testCases(t), `
+print 1 + 2 * 3 - -4;
+print (2 ** 10) % 7 | 8;
+print "a" + "b" + "${1 + 1}c";
+print str(!nil, 1 == 1, "x" != "x");
+print str(true or undefined, false and undefined, nil ?? "default", 1 ?? undefined);
+print str(false or "right", true and "right");
+print 1 < 2 ? "yes" : undefined;
+if (1 > 2) print undefined; else print "else";
+if (nil) print undefined;
+while (false) print undefined;
+fun f(x) {
+  if (true) {
+    var y = x * 2;
+    return y;
+    print undefined;
+    var z = 1;
+  }
+  print undefined;
+}
+print f(21);
+for (var i = 0; i < 3; i++) {
+  var j = i;
+  if (j == 1) continue;
+  print j;
+  break;
+  print undefined;
+}
+print str(1 / 0, " ", -(1 / 0));
+`,
+		"print 1;\nprint 1 + \"a\";",
+		`print -"x";`,
+		"var x = 1;\nprint 2 & 1.5;",
+		"print true ? 1 : 2 << -1;",
+		"print 1 < 2 ? 3 < \"4\" : 5;",
+		"if (false) { return 1; }",
+	)
+	for _, code := range codes {
+		expect := output(code)
+		for _, opts := range []options{{optimize: true}, {optimize: true, vm: true}} {
+			if got := outputWith(code, opts); got != expect {
+				t.Errorf("Output %q with %+v differs from %q for:\n%s\n", got, opts, expect, code)
+			}
+		}
+	}
+
+	folded := map[string]Expr{
+		"print 1 + 2 * 3;":         Literal{numberValue(7)},
+		"print (1 + 2) * 3;":       Literal{numberValue(9)},
+		`print "n=${1 + 1}";`:      Literal{stringValue("n=2")},
+		"print !(1 < 2);":          Literal{boolValue(false)},
+		"print nil ?? 1;":          Literal{numberValue(1)},
+		"print false ? 1 : 2 * 2;": Literal{numberValue(4)},
+		"print true or 1 + \"a\";": Literal{boolValue(true)},
+	}
+	for code, expect := range folded {
+		stmts, err := compile(code, true)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v.\n", err)
+		}
+		if got := stmts[0].(printStmt).expr; got != expect {
+			t.Errorf("Expected %q to fold into %v but get %v.\n", code, expect, got)
+		}
+	}
+
+	stmts, err := compile("print 1 + \"a\";", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
+	if _, ok := stmts[0].(printStmt).expr.(Binary); !ok {
+		t.Errorf("Expected an erroneous expression to stay unfolded.\n")
+	}
+
+	stmts, err = compile("if (false) print 1;\nif (true) print 2; else print 3;\nfun f() { return 1; print 2; }", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v.\n", err)
+	}
+	if len(stmts) != 2 {
+		t.Fatalf("Expected 2 statements but get %d.\n", len(stmts))
+	}
+	if _, ok := stmts[0].(printStmt); !ok {
+		t.Errorf("Expected the constant if to be replaced by its then branch but get %T.\n", stmts[0])
+	}
+	if body := stmts[1].(*functionStmt).stmts; len(body) != 1 {
+		t.Errorf("Expected statements after return to be removed but get %d statements.\n", len(body))
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	code := `
+fun sum(n, acc) {
+  if (n == 0) return acc;
+  return sum(n - 1, acc + n);
+}
+print sum(1000000, 0);
+fun isEven(n) { return n == 0 ? true : isOdd(n - 1); }
+fun isOdd(n) { return (n == 0 ? false : isEven(n - 1)); }
+print isEven(1000000);
+class Counter {
+  count(n) {
+    if (n == 0) return "done";
+    return this.count(n - 1);
+  }
+}
+print Counter().count(1000000);
+fun g() { throw "boom"; }
+fun f() {
+  try {
+    return g();
+  } catch (e) {
+    return "caught " + e;
+  } finally {
+    print "finally";
+  }
+}
+print f();
+fun h() {
+  try {} finally { return len("tail"); }
+}
+print h();
+`
+	expect := "5.000005e+11\ntrue\ndone\nfinally\ncaught boom\n4\n"
+	for _, opts := range []options{{}, {optimize: true}} {
+		if got := outputWith(code, opts); got != expect {
+			t.Errorf("Unexpected output %q with %+v.\n", got, opts)
+		}
+	}
+
+	err := Play("fun loop(n) {\n  if (n == 0) return undefined;\n  return loop(n - 1);\n}\nloop(1000000);")
+	if err == nil || err.Error() != "[line 2] Undefined variable 'undefined'." {
+		t.Errorf("Unexpected error: %v.\n", err)
+	}
+}
@@ -2,66 +2,211 @@ package main
 
 import (
 	"fmt"
-	"reflect"
-	"runtime"
+	"io/fs"
+	"math"
+	"strconv"
 )
 
 type Interpreter struct {
-	global      Table         // 全局变量表
-	local       *Table        // 当前作用域变量表
-	returnStack []interface{} // 函数调用返回值保存栈
+	builtins    *Table             // 原生函数表，所有模块的全局变量表都以它为外层作用域
+	global      *Table             // 当前模块的全局变量表
+	local       *Environment       // 当前局部作用域，位于全局作用域时为nil
+	returnValue Value              // 最近一次return语句的返回值
+	files       fs.FS              // 加载模块使用的文件系统，为nil时不能使用import
+	module      *Module            // 当前正在执行的模块
+	entry       *Module            // 入口模块
+	modules     map[string]*Module // 按路径缓存所有已加载的模块
+	optimize    bool               // 加载模块时是否优化语法树
+	tailCall    tailCall           // return语句留给Function.call执行的尾调用
+}
+
+// 尾调用，function为nil表示没有待执行的尾调用
+type tailCall struct {
+	function *Function
+	args     []Value
 }
 
 func _Interpreter() *Interpreter {
-	global := Table{nil, map[string]interface{}{}}
+	builtins := &Table{nil, map[string]Value{}}
+	for _, native := range natives {
+		builtins.define(native.name, objectValue(native))
+	}
+	global := &Table{builtins, map[string]Value{}}
+	main := &Module{path: "", globals: global}
 	return &Interpreter{
-		global:      global,
-		local:       &global,
-		returnStack: []interface{}{},
+		builtins: builtins,
+		global:   global,
+		module:   main,
+		entry:    main,
+		modules:  map[string]*Module{main.path: main},
 	}
 }
 
 // 解释器执行所有语句
-func (interpreter *Interpreter) interpret(stmts []Stmt) {
+func (interpreter *Interpreter) interpret(stmts []Stmt) (err error) {
+	defer catch(&err)
+	interpreter.execAll(stmts)
+	return nil
+}
+
+// 依次执行语句，遇到控制流信号时立即停止并向外传递
+func (interpreter *Interpreter) execAll(stmts []Stmt) signal {
 	for _, stmt := range stmts {
-		stmt.exec(interpreter)
+		if sig := stmt.exec(interpreter); sig != sigNone {
+			return sig
+		}
 	}
+	return sigNone
 }
 
 // 进入或退出作用域
-func (interpreter *Interpreter) enterScope(target *Table) {
+func (interpreter *Interpreter) enterScope(target *Environment) {
 	interpreter.local = target
 }
 
-// 检查所有操作数的类型是否正确
-func checkOperands(kind reflect.Kind, operator Token, operands ...interface{}) {
+// 执行并捕获其中抛出的运行时错误，出错时恢复到执行前的作用域
+func (interpreter *Interpreter) protect(run func() signal) (sig signal, err *RuntimeError) {
+	scope := interpreter.local
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			interpreter.enterScope(scope)
+			sig, err = sigNone, e
+		}
+	}()
+	return run(), nil
+}
+
+// 对return语句中处于尾部位置的表达式求值
+// 尾调用Lox函数时只记录被调用的函数和参数，由外层的Function.call在循环中执行，避免Go调用栈随递归增长
+func (interpreter *Interpreter) evalTail(expr Expr) Value {
+	switch e := expr.(type) {
+	case Grouping:
+		return interpreter.evalTail(e.expression)
+	case Conditional:
+		if isTrue(e.condition.eval(interpreter)) {
+			return interpreter.evalTail(e.thenBranch)
+		}
+		return interpreter.evalTail(e.elseBranch)
+	case Call:
+		fun, args := e.prepare(interpreter)
+		if function, ok := fun.(*Function); ok {
+			interpreter.tailCall = tailCall{function, args}
+			return nilValue
+		}
+		return fun.call(interpreter, e.paren, args)
+	}
+	return expr.eval(interpreter)
+}
+
+// 在当前作用域中定义变量，全局作用域中的变量保存在全局变量表中
+func (interpreter *Interpreter) define(name string, value Value) {
+	if interpreter.local == nil {
+		interpreter.global.define(name, value)
+	} else {
+		interpreter.local.define(value)
+	}
+}
+
+// 按Resolver计算的作用域距离和槽位查找变量，未解析到局部作用域的变量到全局变量表中查找
+func (interpreter *Interpreter) lookUp(name Token, depth int, slot int) Value {
+	if depth >= 0 {
+		return interpreter.local.getAt(depth, slot)
+	}
+	return interpreter.global.get(name)
+}
+
+// 检查所有操作数是否都是数字
+func checkNumbers(operator Token, operands ...Value) {
 	for _, operand := range operands {
-		if reflect.TypeOf(operand).Kind() != kind {
-			exitWithErr(operator.line, "Operator '"+operator.lexeme+"' expect right operands.")
+		if operand.kind != VAL_NUMBER {
+			runtimeError(operator.line, "Operator '"+operator.lexeme+"' expect right operands.")
 		}
 	}
 }
 
+// 位运算的操作数必须是可以用64位整数表示的整数值
+func toInteger(operator Token, operand Value) int64 {
+	number := operand.asNumber()
+	if !operand.isNumber() || number != math.Trunc(number) || number < math.MinInt64 || number >= math.MaxInt64 {
+		runtimeError(operator.line, "Operator '"+operator.lexeme+"' expect integer operands.")
+	}
+	return int64(number)
+}
+
+// 移位运算的位数必须是非负整数
+func shiftCount(operator Token, operand Value) uint64 {
+	count := toInteger(operator, operand)
+	if count < 0 {
+		runtimeError(operator.line, "Operator '"+operator.lexeme+"' expect non-negative shift count.")
+	}
+	return uint64(count)
+}
+
 // 真值判断
-func isTrue(obj interface{}) bool {
-	if obj == nil || obj == false {
+func isTrue(value Value) bool {
+	switch value.kind {
+	case VAL_NIL:
 		return false
+	case VAL_BOOL:
+		return value.asBool()
 	}
 	return true
 }
 
-// 获得任意类型对应的字符串表示
-func toString(obj interface{}) string {
-	if obj == nil {
+// 获得任意值对应的字符串表示
+func toString(value Value) string {
+	return formatValue(value, nil)
+}
+
+// printing记录正在输出的容器，用于在容器包含自身时停止展开
+func formatValue(value Value, printing visiting) string {
+	switch value.kind {
+	case VAL_NIL:
 		return "nil"
+	case VAL_BOOL:
+		return strconv.FormatBool(value.asBool())
+	case VAL_NUMBER:
+		return fmt.Sprint(value.asNumber())
+	case VAL_STRING:
+		return value.asString()
 	}
-	if reflect.TypeOf(obj).Kind() == reflect.TypeOf(Function{}).Kind() {
-		return "<fun $" + obj.(Function).declaration.name.lexeme + ">"
+	switch object := value.ref.(type) {
+	case *Function:
+		return funString(object.declaration.name.lexeme)
+	case *Native:
+		return "<native fun $" + object.name + ">"
+	case *Class:
+		return "<class $" + object.name + ">"
+	case *Instance:
+		return "<instance $" + object.class.name + ">"
+	case *List:
+		return object.format(printing)
+	case *Map:
+		return object.format(printing)
+	case *Module:
+		return "<module $" + object.path + ">"
+	case *Closure:
+		return funString(object.proto.name)
+	case *BoundMethod:
+		return funString(object.method.proto.name)
+	case *VMClass:
+		return "<class $" + object.name + ">"
+	case *VMInstance:
+		return "<instance $" + object.class.name + ">"
+	case *ErrorObject:
+		return fmt.Sprintf("[line %d] %s", object.line, object.message)
 	}
-	return fmt.Sprint(obj)
+	return fmt.Sprint(value.ref)
 }
 
-func exitWithErr(line int, message string) {
-	out("[line %d] %s\n", line, message)
-	runtime.Goexit()
+// 函数的字符串表示，匿名函数没有名字
+func funString(name string) string {
+	if name == "" {
+		return "<anonymous fun>"
+	}
+	return "<fun $" + name + ">"
 }
@@ -1,10 +1,16 @@
 package main
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
-	// 源代码
-	source string
+	// 源代码，按Unicode字符（rune）处理
+	source []rune
 	// token流
 	tokens []Token
 	// token起始位置
@@ -13,11 +19,21 @@ type Lexer struct {
 	current int
 	// 所在行
 	line int
+	// 当前行起始位置
+	lineStart int
+	// token起始位置所在列，从1开始按字符计数
+	column int
+	// 词法分析中遇到的全部错误
+	errors ErrorList
+	// 每层未结束的字符串插值中尚未闭合的'{'个数
+	braces []int
 }
 
 func _Lexer(source string) *Lexer {
+	// 忽略开头的BOM
+	source = strings.TrimPrefix(source, "\uFEFF")
 	return &Lexer{
-		source:  source,
+		source:  []rune(source),
 		tokens:  []Token{},
 		start:   0,
 		current: 0,
@@ -26,14 +42,21 @@ func _Lexer(source string) *Lexer {
 }
 
 // 词法分析器处理
-func (lexer *Lexer) lex() []Token {
+func (lexer *Lexer) lex() ([]Token, error) {
 	for !lexer.eof() {
 		// 扫描下一个token
 		lexer.start = lexer.current
+		lexer.column = lexer.current - lexer.lineStart + 1
 		lexer.scanToken()
 	}
+	if len(lexer.braces) > 0 {
+		lexer.error("Unterminated string interpolation.")
+	}
 	lexer.tokens = append(lexer.tokens, _Token(EOF, "$EOF", nil, lexer.line))
-	return lexer.tokens
+	if len(lexer.errors) > 0 {
+		return nil, lexer.errors
+	}
+	return lexer.tokens, nil
 }
 
 func (lexer *Lexer) scanToken() {
@@ -43,33 +66,88 @@ func (lexer *Lexer) scanToken() {
 	case '\r':
 	case '\t':
 	case '\n':
-		lexer.line++
+		lexer.newline()
 	case '(':
 		lexer.addToken(LEFT_PAREN, nil)
 	case ')':
 		lexer.addToken(RIGHT_PAREN, nil)
 	case '{':
+		if len(lexer.braces) > 0 {
+			lexer.braces[len(lexer.braces)-1]++
+		}
 		lexer.addToken(LEFT_BRACE, nil)
 	case '}':
+		if len(lexer.braces) > 0 {
+			top := len(lexer.braces) - 1
+			// 插值表达式结束，继续扫描字符串的剩余部分
+			if lexer.braces[top] == 0 {
+				lexer.braces = lexer.braces[:top]
+				lexer.string()
+				return
+			}
+			lexer.braces[top]--
+		}
 		lexer.addToken(RIGHT_BRACE, nil)
+	case '[':
+		lexer.addToken(LEFT_BRACKET, nil)
+	case ']':
+		lexer.addToken(RIGHT_BRACKET, nil)
 	case ',':
 		lexer.addToken(COMMA, nil)
+	case ':':
+		lexer.addToken(COLON, nil)
 	case ';':
 		lexer.addToken(SEMICOLON, nil)
 	case '.':
 		lexer.addToken(DOT, nil)
 	case '+':
-		lexer.addToken(PLUS, nil)
+		if lexer.match('+') {
+			lexer.addToken(PLUS_PLUS, nil)
+		} else if lexer.match('=') {
+			lexer.addToken(PLUS_EQUAL, nil)
+		} else {
+			lexer.addToken(PLUS, nil)
+		}
 	case '-':
-		lexer.addToken(MINUS, nil)
+		if lexer.match('-') {
+			lexer.addToken(MINUS_MINUS, nil)
+		} else if lexer.match('=') {
+			lexer.addToken(MINUS_EQUAL, nil)
+		} else {
+			lexer.addToken(MINUS, nil)
+		}
 	case '*':
-		lexer.addToken(STAR, nil)
+		if lexer.match('*') {
+			lexer.addToken(STAR_STAR, nil)
+		} else if lexer.match('=') {
+			lexer.addToken(STAR_EQUAL, nil)
+		} else {
+			lexer.addToken(STAR, nil)
+		}
+	case '%':
+		lexer.addToken(PERCENT, nil)
+	case '&':
+		lexer.addToken(AMPERSAND, nil)
+	case '|':
+		lexer.addToken(PIPE, nil)
+	case '^':
+		lexer.addToken(CARET, nil)
+	case '~':
+		lexer.addToken(TILDE, nil)
+	case '?':
+		if lexer.match('?') {
+			lexer.addToken(QUESTION_QUESTION, nil)
+		} else {
+			lexer.addToken(QUESTION, nil)
+		}
 	case '/':
 		// 处理单行注释
 		if lexer.match('/') {
 			for !lexer.eof() && lexer.peek() != '\n' {
 				lexer.next()
 			}
+		} else if lexer.match('=') {
+			lexer.addToken(SLASH_EQUAL, nil)
 		} else {
 			lexer.addToken(SLASH, nil)
 		}
@@ -90,76 +168,242 @@ func (lexer *Lexer) scanToken() {
 			lexer.addToken(EQUAL, nil)
 		}
 	case '>':
-		if lexer.match('=') {
+		if lexer.match('>') {
+			lexer.addToken(GREATER_GREATER, nil)
+		} else if lexer.match('=') {
 			lexer.addToken(GREATER_EQUAL, nil)
 		} else {
 			lexer.addToken(GREATER, nil)
 		}
 	case '<':
-		if lexer.match('=') {
+		if lexer.match('<') {
+			lexer.addToken(LESS_LESS, nil)
+		} else if lexer.match('=') {
 			lexer.addToken(LESS_EQUAL, nil)
 		} else {
 			lexer.addToken(LESS, nil)
 		}
 	case '"':
 		// 处理字符串String
-		for !lexer.eof() && lexer.peek() != '"' {
-			if lexer.peek() == '\n' {
-				lexer.line++
-			}
-			lexer.next()
-		}
-		if lexer.eof() {
-			exitWithErr(lexer.line, "Unterminated string.")
-		}
-		lexer.next()
-		str := lexer.source[lexer.start+1 : lexer.current-1]
-		lexer.addToken(STRING, str)
+		lexer.string()
 	default:
 		if isDigit(char) {
 			// 处理数字Number
-			for isDigit(lexer.peek()) {
+			lexer.number(char)
+		} else if isAlpha(char) {
+			// 处理标识符Identifier
+			for isAlphaOrDigit(lexer.peek()) {
 				lexer.next()
 			}
-			if lexer.peek() == '.' && isDigit(lexer.peekNext()) {
+			lexer.addToken(findType(lexer.lexeme()), nil)
+		} else {
+			lexer.error("Unexpected character.")
+		}
+	}
+}
+
+// 扫描数字字面量：十进制小数和指数，0x十六进制、0b二进制、0o八进制整数，数字之间可以用'_'分隔
+func (lexer *Lexer) number(first rune) {
+	if first == '0' {
+		switch lexer.peek() {
+		case 'x', 'X':
+			lexer.next()
+			lexer.radix(16, isHexDigit, "hexadecimal")
+			return
+		case 'b', 'B':
+			lexer.next()
+			lexer.radix(2, isBinaryDigit, "binary")
+			return
+		case 'o', 'O':
+			lexer.next()
+			lexer.radix(8, isOctalDigit, "octal")
+			return
+		}
+	}
+	ok := lexer.digits(isDigit)
+	if lexer.peek() == '.' && isDigit(lexer.peekNext()) {
+		lexer.next()
+		ok = lexer.digits(isDigit) && ok
+	}
+	if lexer.peek() == 'e' || lexer.peek() == 'E' {
+		lexer.next()
+		if lexer.peek() == '+' || lexer.peek() == '-' {
+			lexer.next()
+		}
+		if !isDigit(lexer.peek()) {
+			lexer.error("Expect digits in exponent of number '" + lexer.lexeme() + "'.")
+			return
+		}
+		ok = lexer.digits(isDigit) && ok
+	}
+	if !ok {
+		lexer.separatorError()
+		return
+	}
+	double, err := strconv.ParseFloat(strings.ReplaceAll(lexer.lexeme(), "_", ""), 64)
+	if err != nil {
+		lexer.error("Number '" + lexer.lexeme() + "' is out of range.")
+		return
+	}
+	lexer.addToken(NUMBER, double)
+}
+
+// 扫描带进制前缀的整数
+func (lexer *Lexer) radix(base float64, valid func(rune) bool, name string) {
+	if lexer.peek() == '_' || !isAlphaOrDigit(lexer.peek()) {
+		lexer.error("Expect " + name + " digits after '" + lexer.lexeme() + "'.")
+		return
+	}
+	ok := lexer.digits(valid)
+	if isAlphaOrDigit(lexer.peek()) {
+		invalid := lexer.peek()
+		for isAlphaOrDigit(lexer.peek()) {
+			lexer.next()
+		}
+		lexer.error(fmt.Sprintf("Invalid digit '%c' in %s number '%s'.", invalid, name, lexer.lexeme()))
+		return
+	}
+	if !ok {
+		lexer.separatorError()
+		return
+	}
+	// 逐位累加，超出64位整数范围的常量也能得到近似的浮点数值
+	value := 0.0
+	for _, c := range lexer.source[lexer.start+2 : lexer.current] {
+		if c != '_' {
+			value = value*base + float64(digitValue(c))
+		}
+	}
+	lexer.addToken(NUMBER, value)
+}
+
+// 扫描连续的数字，'_'只能出现在两个数字之间，出现在其他位置时返回false
+func (lexer *Lexer) digits(valid func(rune) bool) bool {
+	ok := true
+	for valid(lexer.peek()) || lexer.peek() == '_' {
+		if lexer.next() == '_' && !valid(lexer.peek()) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// 扫描完整个数字字面量后再报告'_'的位置错误，错误信息中包含完整的字面量
+func (lexer *Lexer) separatorError() {
+	lexer.error("Digit separator '_' must be between digits in number '" + lexer.lexeme() + "'.")
+}
+
+// 扫描字符串内容直到结束引号，遇到"${"时生成INTERPOLATION并转入插值表达式的扫描
+func (lexer *Lexer) string() {
+	var builder strings.Builder
+	for !lexer.eof() && lexer.peek() != '"' {
+		char := lexer.next()
+		switch {
+		case char == '\\':
+			lexer.escape(&builder)
+		case char == '$' && lexer.peek() == '{':
+			lexer.next()
+			lexer.addToken(INTERPOLATION, builder.String())
+			lexer.braces = append(lexer.braces, 0)
+			return
+		default:
+			if char == '\n' {
+				lexer.newline()
+			}
+			builder.WriteRune(char)
+		}
+	}
+	if lexer.eof() {
+		lexer.error("Unterminated string.")
+		return
+	}
+	lexer.next()
+	lexer.addToken(STRING, builder.String())
+}
+
+// 处理反斜杠之后的转义序列
+func (lexer *Lexer) escape(builder *strings.Builder) {
+	if lexer.eof() {
+		return
+	}
+	char := lexer.next()
+	switch char {
+	case 'n':
+		builder.WriteRune('\n')
+	case 't':
+		builder.WriteRune('\t')
+	case 'r':
+		builder.WriteRune('\r')
+	case '0':
+		builder.WriteRune(0)
+	case '"', '\\', '$':
+		builder.WriteRune(char)
+	case 'u':
+		// \uXXXX 或 \u{X...}
+		var hex string
+		braced := lexer.match('{')
+		if braced {
+			begin := lexer.current
+			for !lexer.eof() && lexer.peek() != '}' && lexer.peek() != '"' {
 				lexer.next()
-				for isDigit(lexer.peek()) {
-					lexer.next()
-				}
 			}
-			double, err := strconv.ParseFloat(lexer.source[lexer.start:lexer.current], 64)
-			if err != nil {
-				exitWithErr(lexer.line, err.Error())
+			hex = string(lexer.source[begin:lexer.current])
+			if !lexer.match('}') {
+				lexer.error("Unterminated unicode escape sequence.")
+				return
 			}
-			lexer.addToken(NUMBER, double)
-		} else if isAlpha(char) {
-			// 处理标识符Identifier
-			for isAlphaOrDigit(lexer.peek()) {
+		} else {
+			begin := lexer.current
+			for i := 0; i < 4 && isHexDigit(lexer.peek()); i++ {
 				lexer.next()
 			}
-			lexer.addToken(findType(lexer.source[lexer.start:lexer.current]), nil)
-		} else {
-			exitWithErr(lexer.line, "Unexpected character.")
+			hex = string(lexer.source[begin:lexer.current])
+		}
+		code, err := strconv.ParseUint(hex, 16, 32)
+		// 不带花括号时必须正好是4位十六进制数字
+		if err != nil || len(hex) == 0 || len(hex) > 6 || !braced && len(hex) != 4 || !utf8.ValidRune(rune(code)) {
+			lexer.error("Invalid unicode escape sequence '\\u" + hex + "'.")
+			return
 		}
+		builder.WriteRune(rune(code))
+	default:
+		lexer.error("Invalid escape sequence '\\" + string(char) + "'.")
 	}
 }
 
+// 记录错误后继续扫描，一次报告所有词法错误
+func (lexer *Lexer) error(message string) {
+	lexer.errors = append(lexer.errors, &LexError{lexer.line, lexer.column, message})
+}
+
 func (lexer *Lexer) addToken(tokenType uint8, literal interface{}) {
-	lexeme := lexer.source[lexer.start:lexer.current]
-	lexer.tokens = append(lexer.tokens, _Token(tokenType, lexeme, literal, lexer.line))
+	token := _Token(tokenType, lexer.lexeme(), literal, lexer.line)
+	token.column = lexer.column
+	lexer.tokens = append(lexer.tokens, token)
+}
+
+// 当前token的原始文本
+func (lexer *Lexer) lexeme() string {
+	return string(lexer.source[lexer.start:lexer.current])
+}
+
+// 换行后更新行号和行起始位置
+func (lexer *Lexer) newline() {
+	lexer.line++
+	lexer.lineStart = lexer.current
 }
 
 func (lexer *Lexer) eof() bool {
 	return lexer.current >= len(lexer.source)
 }
 
-func (lexer *Lexer) next() byte {
+func (lexer *Lexer) next() rune {
 	char := lexer.source[lexer.current]
 	lexer.current++
 	return char
 }
 
-func (lexer *Lexer) match(expected byte) bool {
+func (lexer *Lexer) match(expected rune) bool {
 	if !lexer.eof() && lexer.source[lexer.current] == expected {
 		lexer.current++
 		return true
@@ -167,28 +411,55 @@ func (lexer *Lexer) match(expected byte) bool {
 	return false
 }
 
-func (lexer *Lexer) peek() byte {
+func (lexer *Lexer) peek() rune {
 	if lexer.eof() {
 		return 0
 	}
 	return lexer.source[lexer.current]
 }
 
-func (lexer *Lexer) peekNext() byte {
+func (lexer *Lexer) peekNext() rune {
 	if lexer.current+1 >= len(lexer.source) {
 		return 0
 	}
 	return lexer.source[lexer.current+1]
 }
 
-func isAlphaOrDigit(c byte) bool {
-	return isDigit(c) || isAlpha(c)
+// 标识符中除首字符外可以包含任意Unicode数字
+func isAlphaOrDigit(c rune) bool {
+	return unicode.IsDigit(c) || isAlpha(c)
 }
 
-func isDigit(c byte) bool {
+// 数字字面量只使用ASCII数字
+func isDigit(c rune) bool {
 	return c >= '0' && c <= '9'
 }
 
-func isAlpha(c byte) bool {
-	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+func isHexDigit(c rune) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isBinaryDigit(c rune) bool {
+	return c == '0' || c == '1'
+}
+
+func isOctalDigit(c rune) bool {
+	return c >= '0' && c <= '7'
+}
+
+// 十六进制及以下进制数字字符对应的值
+func digitValue(c rune) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	default:
+		return int(c - '0')
+	}
+}
+
+// 标识符可以以任意Unicode字母或下划线开头
+func isAlpha(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
 }
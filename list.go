@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// List 运行时的列表对象，按引用比较是否相等
+type List struct {
+	elements []Value
+}
+
+func (list *List) index(bracket Token, index Value) int {
+	return checkIndex(bracket, "List", index, len(list.elements))
+}
+
+// 检查下标是否为[0, length)范围内的整数，返回对应的int下标
+func checkIndex(bracket Token, kind string, index Value, length int) int {
+	number := index.asNumber()
+	if !index.isNumber() || number != math.Trunc(number) {
+		runtimeError(bracket.line, kind+" index must be an integer.")
+	}
+	if number < 0 || number >= float64(length) {
+		runtimeError(bracket.line, kind+" index out of range.")
+	}
+	return int(number)
+}
+
+func (list *List) format(printing visiting) string {
+	return printing.enter(list, "[...]", func(printing visiting) string {
+		elements := make([]string, len(list.elements))
+		for i, element := range list.elements {
+			elements[i] = quote(element, printing)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	})
+}
+
+// 正在输出的列表和字典
+type visiting map[interface{}]bool
+
+// 输出容器的内容，容器已经在输出中（即包含自身）时返回省略形式cycle
+func (v visiting) enter(container interface{}, cycle string, content func(visiting) string) string {
+	if v[container] {
+		return cycle
+	}
+	if v == nil {
+		v = visiting{}
+	}
+	v[container] = true
+	defer delete(v, container)
+	return content(v)
+}
+
+// 容器中的字符串元素加上引号输出
+func quote(value Value, printing visiting) string {
+	if value.isString() {
+		return strconv.Quote(value.asString())
+	}
+	return formatValue(value, printing)
+}
@@ -2,24 +2,52 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
+// 执行选项
+type options struct {
+	vm       bool // 使用字节码虚拟机代替树遍历解释器执行
+	optimize bool // 执行前对语法树进行常量折叠等优化
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: glox [InputFile]")
+	var opts options
+	flag.BoolVar(&opts.vm, "vm", false, "run with the bytecode virtual machine")
+	flag.BoolVar(&opts.optimize, "O", false, "optimize the syntax tree before running")
+	flag.Usage = func() {
+		fmt.Println("Usage: glox [-vm] [-O] [InputFile]")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(64)
 	}
-	bts, err := ioutil.ReadFile(os.Args[1])
+	file := flag.Arg(0)
+	bts, err := ioutil.ReadFile(file)
 	if err != nil {
-		fmt.Printf("Failed to read file '%s'.\n", os.Args[1])
+		fmt.Printf("Failed to read file '%s'.\n", file)
 		os.Exit(65)
 	}
 
-	Play(string(bts))
+	// 模块路径相对于入口文件所在目录解析，文件系统以入口文件所在卷的根目录为根，使"../"可以访问上层目录
+	path, _ := filepath.Abs(file)
+	root := filepath.VolumeName(path) + string(filepath.Separator)
+	name, _ := filepath.Rel(root, path)
+	if err := run(os.DirFS(root), filepath.ToSlash(name), string(bts), opts); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		// 编译期错误和运行时错误使用不同的退出码
+		if _, ok := err.(*RuntimeError); ok {
+			os.Exit(70)
+		}
+		os.Exit(65)
+	}
 }
 
 var Buf bytes.Buffer
@@ -34,10 +62,67 @@ func out(format string, a ...interface{}) {
 	_, _ = fmt.Fprintf(writer, format, a...)
 }
 
+// 依次执行词法分析、语法分析和静态解析，optimize为true时再对语法树进行优化
+func compile(code string, optimize bool) ([]Stmt, error) {
+	tokens, err := _Lexer(code).lex()
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := _Parser(tokens).parse()
+	if err != nil {
+		return nil, err
+	}
+	if err := _Resolver().resolveAll(stmts); err != nil {
+		return nil, err
+	}
+	if optimize {
+		stmts = optimizeAll(stmts)
+		// 优化可能删除局部变量的声明，需要重新分配槽位
+		if err := _Resolver().resolveAll(stmts); err != nil {
+			return nil, err
+		}
+	}
+	return stmts, nil
+}
+
+// 编译并解释执行名为name的入口模块，import语句从files中加载其他模块
+func run(files fs.FS, name string, code string, opts options) error {
+	stmts, err := compile(code, opts.optimize)
+	if err != nil {
+		return err
+	}
+	if opts.vm {
+		script, err := compileScript(stmts)
+		if err != nil {
+			return err
+		}
+		vm := _VM()
+		vm.files = files
+		vm.optimize = opts.optimize
+		vm.entry.path = name
+		vm.modules = map[string]*Module{name: vm.entry}
+		return vm.interpret(script)
+	}
+	interpreter := _Interpreter()
+	interpreter.files = files
+	interpreter.optimize = opts.optimize
+	interpreter.module.path = name
+	interpreter.modules = map[string]*Module{name: interpreter.module}
+	return interpreter.interpret(stmts)
+}
+
 // Play 可以编译成动态链接库作为插件开放给其他程序调用
-func Play(code string) {
+// 执行的输出和错误信息都保存在Buf中，出错时返回对应的LexError、ParseError或RuntimeError
+func Play(code string) error {
+	return PlayFS(nil, code)
+}
+
+// PlayFS 与Play相同，import语句从files中加载模块，模块路径相对于files的根目录
+func PlayFS(files fs.FS, code string) error {
 	Buf.Reset()
-	tokens := _Lexer(code).lex()
-	stmts := _Parser(tokens).parse()
-	_Interpreter().interpret(stmts)
+	err := run(files, "", code, options{})
+	if err != nil {
+		Buf.WriteString(err.Error() + "\n")
+	}
+	return err
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Map 运行时的字典对象，键只能是字符串、数字、布尔值或nil
+type Map struct {
+	entries map[Value]Value
+}
+
+// 检查键是否可以作为字典的键，NaN与自身不相等，同样不能作为键
+func checkKey(token Token, key Value) {
+	if key.kind == VAL_OBJECT || key.isNumber() && math.IsNaN(key.asNumber()) {
+		runtimeError(token.line, "Unhashable map key '"+toString(key)+"'.")
+	}
+}
+
+// 读取不存在的键时返回nil
+func (m *Map) get(token Token, key Value) Value {
+	checkKey(token, key)
+	return m.entries[key]
+}
+
+func (m *Map) set(token Token, key Value, value Value) {
+	checkKey(token, key)
+	m.entries[key] = value
+}
+
+// 按nil、布尔值、数字、字符串的顺序排列所有键，同类型的键按值排序
+func (m *Map) sortedKeys() []Value {
+	keys := make([]Value, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(keys[i], keys[j])
+	})
+	return keys
+}
+
+// 类型标签的顺序即不同类型键的排列顺序
+func lessKey(a Value, b Value) bool {
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	if a.isString() {
+		return a.asString() < b.asString()
+	}
+	return a.number < b.number
+}
+
+func (m *Map) format(printing visiting) string {
+	return printing.enter(m, "{...}", func(printing visiting) string {
+		entries := make([]string, 0, len(m.entries))
+		for _, key := range m.sortedKeys() {
+			entries = append(entries, quote(key, printing)+": "+quote(m.entries[key], printing))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	})
+}
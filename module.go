@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+)
+
+// Module 通过import加载的模块，每个模块在独立的全局变量表中执行且只加载一次
+type Module struct {
+	path    string
+	globals *Table
+	loaded  bool // 模块代码是否已执行完毕，加载过程中再次导入说明存在循环导入
+}
+
+// 读取模块的顶层定义
+func (module *Module) get(name Token) Value {
+	if value, ok := module.globals.values[name.lexeme]; ok {
+		return value
+	}
+	runtimeError(name.line, "Undefined name '"+name.lexeme+"' in module '"+module.path+"'.")
+	return nilValue
+}
+
+// 运行时错误离开模块中的代码时记录出错的模块，以最先经过的函数或模块顶层代码为准
+// 入口模块中的错误不记录模块路径
+func (interpreter *Interpreter) locate(r interface{}) {
+	if e, ok := r.(*RuntimeError); ok && !e.located {
+		e.located = true
+		if interpreter.module != interpreter.entry {
+			e.Module = interpreter.module.path
+		}
+	}
+}
+
+// 计算导入的模块路径，相对于导入它的模块所在目录
+func resolveModule(files fs.FS, line int, importer *Module, name string) string {
+	if files == nil {
+		runtimeError(line, "Can't import modules without a file system.")
+	}
+	return path.Join(path.Dir(importer.path), name)
+}
+
+// 查找已加载的模块，模块仍在加载中说明存在循环导入
+func cachedModule(modules map[string]*Module, line int, modulePath string) (*Module, bool) {
+	module, ok := modules[modulePath]
+	if ok && !module.loaded {
+		runtimeError(line, "Cyclic import of module '"+modulePath+"'.")
+	}
+	return module, ok
+}
+
+// 读取并编译模块的源代码
+func parseModule(files fs.FS, line int, modulePath string, optimize bool) []Stmt {
+	source, err := fs.ReadFile(files, modulePath)
+	if err != nil {
+		runtimeError(line, "Can't read module '"+modulePath+"'.")
+	}
+	stmts, err := compile(string(source), optimize)
+	if err != nil {
+		runtimeError(line, "Failed to compile module '"+modulePath+"':\n"+err.Error())
+	}
+	return stmts
+}
+
+// 加载并执行模块，路径相对于当前模块所在目录
+func (interpreter *Interpreter) load(keyword Token, name string) *Module {
+	modulePath := resolveModule(interpreter.files, keyword.line, interpreter.module, name)
+	if module, ok := cachedModule(interpreter.modules, keyword.line, modulePath); ok {
+		return module
+	}
+	stmts := parseModule(interpreter.files, keyword.line, modulePath, interpreter.optimize)
+
+	module := &Module{
+		path:    modulePath,
+		globals: &Table{interpreter.builtins, map[string]Value{}},
+	}
+	interpreter.modules[modulePath] = module
+
+	// 切换到模块自己的全局变量表中执行，结束后恢复
+	enclosing, global, local := interpreter.module, interpreter.global, interpreter.local
+	interpreter.module, interpreter.global, interpreter.local = module, module.globals, nil
+	defer func() {
+		r := recover()
+		if r != nil {
+			interpreter.locate(r)
+		}
+		interpreter.module, interpreter.global, interpreter.local = enclosing, global, local
+		// 执行出错的模块不保留在缓存中
+		if !module.loaded {
+			delete(interpreter.modules, modulePath)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	interpreter.execAll(stmts)
+	module.loaded = true
+	return module
+}
+
+// 虚拟机加载并执行模块，模块的脚本函数在新的栈帧中运行，其中的闭包都使用模块自己的全局变量表
+func (vm *VM) load(line int, importer *Module, name string) *Module {
+	modulePath := resolveModule(vm.files, line, importer, name)
+	if module, ok := cachedModule(vm.modules, line, modulePath); ok {
+		return module
+	}
+	script, err := compileScript(parseModule(vm.files, line, modulePath, vm.optimize))
+	if err != nil {
+		runtimeError(line, "Failed to compile module '"+modulePath+"':\n"+err.Error())
+	}
+
+	module := &Module{
+		path:    modulePath,
+		globals: &Table{vm.builtins, map[string]Value{}},
+	}
+	vm.modules[modulePath] = module
+	// 执行出错的模块不保留在缓存中
+	defer func() {
+		if !module.loaded {
+			delete(vm.modules, modulePath)
+		}
+	}()
+	closure := &Closure{proto: script, module: module}
+	vm.push(objectValue(closure))
+	vm.call(closure, 0, line)
+	vm.execute(len(vm.frames) - 1)
+	module.loaded = true
+	return module
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Callable 可以被调用的对象：Lox函数、类和Go实现的原生函数
+type Callable interface {
+	// 参数个数，负数表示接受任意个数的参数
+	arity() int
+	// paren为调用处的右括号，用于报告运行时错误的行号
+	call(interpreter *Interpreter, paren Token, args []Value) Value
+}
+
+// Native Go实现的原生函数
+type Native struct {
+	name       string
+	paramCount int
+	fn         func(interpreter *Interpreter, paren Token, args []Value) Value
+}
+
+func (n *Native) arity() int {
+	return n.paramCount
+}
+
+func (n *Native) call(interpreter *Interpreter, paren Token, args []Value) Value {
+	return n.fn(interpreter, paren, args)
+}
+
+// 解释器启动时定义到全局变量表中的原生函数
+var natives = []*Native{
+	// 返回当前时间的秒数
+	{"clock", 0, func(interpreter *Interpreter, paren Token, args []Value) Value {
+		return numberValue(float64(time.Now().UnixNano()) / float64(time.Second))
+	}},
+	// 将所有参数转换为字符串后拼接
+	{"str", -1, func(interpreter *Interpreter, paren Token, args []Value) Value {
+		var builder strings.Builder
+		for _, arg := range args {
+			builder.WriteString(toString(arg))
+		}
+		return stringValue(builder.String())
+	}},
+	// 返回字符串（按Unicode字符计数）、列表或字典的长度
+	{"len", 1, func(interpreter *Interpreter, paren Token, args []Value) Value {
+		if args[0].isString() {
+			return numberValue(float64(utf8.RuneCountInString(args[0].asString())))
+		}
+		switch value := args[0].asObject().(type) {
+		case *List:
+			return numberValue(float64(len(value.elements)))
+		case *Map:
+			return numberValue(float64(len(value.entries)))
+		}
+		runtimeError(paren.line, "Can only get length of strings, lists and maps.")
+		return nilValue
+	}},
+	// 按固定顺序返回字典所有键组成的列表
+	{"keys", 1, func(interpreter *Interpreter, paren Token, args []Value) Value {
+		m, ok := args[0].asObject().(*Map)
+		if !ok {
+			runtimeError(paren.line, "Can only get keys of maps.")
+		}
+		return objectValue(&List{m.sortedKeys()})
+	}},
+	// 在列表末尾追加元素
+	{"push", 2, func(interpreter *Interpreter, paren Token, args []Value) Value {
+		list, ok := args[0].asObject().(*List)
+		if !ok {
+			runtimeError(paren.line, "Can only push to lists.")
+		}
+		list.elements = append(list.elements, args[1])
+		return nilValue
+	}},
+}
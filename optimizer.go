@@ -0,0 +1,295 @@
+package main
+
+// 优化所有语句：折叠常量表达式，删除不会执行的分支和语句
+func optimizeAll(stmts []Stmt) []Stmt {
+	result := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		stmt = stmt.optimize()
+		// 被整体删除的语句优化为空语句块
+		if block, ok := stmt.(*blockStmt); ok && len(block.stmts) == 0 {
+			continue
+		}
+		result = append(result, stmt)
+		// return、break、continue、throw之后的语句不会被执行
+		switch stmt.(type) {
+		case returnStmt, breakStmt, continueStmt, throwStmt:
+			return result
+		}
+	}
+	return result
+}
+
+// 在编译期计算常量表达式，求值出错时放弃折叠，让错误在运行时照常发生
+func fold(eval func() Value) (result Expr, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isRuntimeError := r.(*RuntimeError); !isRuntimeError {
+				panic(r)
+			}
+			result, ok = nil, false
+		}
+	}()
+	return Literal{eval()}, true
+}
+
+/*  ===================  Statement  ===================  */
+
+func (e exprStmt) optimize() Stmt {
+	return exprStmt{e.expr.optimize()}
+}
+
+func (p printStmt) optimize() Stmt {
+	return printStmt{p.expr.optimize()}
+}
+
+func (v varStmt) optimize() Stmt {
+	if v.initializer != nil {
+		v.initializer = v.initializer.optimize()
+	}
+	return v
+}
+
+func (b *blockStmt) optimize() Stmt {
+	return &blockStmt{stmts: optimizeAll(b.stmts)}
+}
+
+func (i ifStmt) optimize() Stmt {
+	condition := i.condition.optimize()
+	if literal, ok := condition.(Literal); ok {
+		if isTrue(literal.value) {
+			return i.thenBranch.optimize()
+		}
+		if i.elseBranch != nil {
+			return i.elseBranch.optimize()
+		}
+		return &blockStmt{}
+	}
+	i.condition = condition
+	i.thenBranch = i.thenBranch.optimize()
+	if i.elseBranch != nil {
+		i.elseBranch = i.elseBranch.optimize()
+	}
+	return i
+}
+
+func (w whileStmt) optimize() Stmt {
+	condition := w.condition.optimize()
+	if literal, ok := condition.(Literal); ok && !isTrue(literal.value) {
+		return &blockStmt{}
+	}
+	w.condition = condition
+	w.body = w.body.optimize()
+	if w.increment != nil {
+		w.increment = w.increment.optimize()
+	}
+	return w
+}
+
+func (f *functionStmt) optimize() Stmt {
+	return f.optimizeBody()
+}
+
+// 优化函数体，方法和匿名函数共用
+func (f *functionStmt) optimizeBody() *functionStmt {
+	return &functionStmt{name: f.name, params: f.params, stmts: optimizeAll(f.stmts)}
+}
+
+func (c classStmt) optimize() Stmt {
+	methods := make([]*functionStmt, len(c.methods))
+	for i, method := range c.methods {
+		methods[i] = method.optimizeBody()
+	}
+	if c.superclass != nil {
+		c.superclass = c.superclass.optimize()
+	}
+	c.methods = methods
+	return c
+}
+
+func (r returnStmt) optimize() Stmt {
+	if r.value != nil {
+		r.value = r.value.optimize()
+	}
+	return r
+}
+
+func (i importStmt) optimize() Stmt {
+	return i
+}
+
+func (t throwStmt) optimize() Stmt {
+	t.value = t.value.optimize()
+	return t
+}
+
+func (t tryStmt) optimize() Stmt {
+	t.body = t.body.optimize()
+	if t.catchBranch != nil {
+		t.catchBranch = t.catchBranch.optimize()
+	}
+	if t.finallyBranch != nil {
+		t.finallyBranch = t.finallyBranch.optimize()
+	}
+	return t
+}
+
+func (b breakStmt) optimize() Stmt {
+	return b
+}
+
+func (c continueStmt) optimize() Stmt {
+	return c
+}
+
+/*  ===================  Expression  ===================  */
+
+func (l Literal) optimize() Expr {
+	return l
+}
+
+func (u Unary) optimize() Expr {
+	u.right = u.right.optimize()
+	if right, ok := u.right.(Literal); ok {
+		if folded, ok := fold(func() Value { return unaryOp(u.operator, right.value) }); ok {
+			return folded
+		}
+	}
+	return u
+}
+
+func (b Binary) optimize() Expr {
+	b.left = b.left.optimize()
+	b.right = b.right.optimize()
+	left, leftOk := b.left.(Literal)
+	right, rightOk := b.right.(Literal)
+	if leftOk && rightOk {
+		if folded, ok := fold(func() Value { return binaryOp(b.operator, left.value, right.value) }); ok {
+			return folded
+		}
+	}
+	return b
+}
+
+// 括号只影响语法分析，优化时直接去掉
+func (g Grouping) optimize() Expr {
+	return g.expression.optimize()
+}
+
+func (v *Variable) optimize() Expr {
+	return v
+}
+
+func (a *Assign) optimize() Expr {
+	a.value = a.value.optimize()
+	return a
+}
+
+func (p Postfix) optimize() Expr {
+	return p
+}
+
+func (l Logical) optimize() Expr {
+	l.left = l.left.optimize()
+	l.right = l.right.optimize()
+	left, ok := l.left.(Literal)
+	if !ok {
+		return l
+	}
+	// 左侧为常量时可以确定结果是左侧的值还是右侧表达式的值
+	var shortCircuit bool
+	switch l.operator.tokenType {
+	case OR:
+		shortCircuit = isTrue(left.value)
+	case QUESTION_QUESTION:
+		shortCircuit = !left.value.isNil()
+	default:
+		shortCircuit = !isTrue(left.value)
+	}
+	if shortCircuit {
+		return left
+	}
+	return l.right
+}
+
+func (c Conditional) optimize() Expr {
+	c.condition = c.condition.optimize()
+	if literal, ok := c.condition.(Literal); ok {
+		if isTrue(literal.value) {
+			return c.thenBranch.optimize()
+		}
+		return c.elseBranch.optimize()
+	}
+	c.thenBranch = c.thenBranch.optimize()
+	c.elseBranch = c.elseBranch.optimize()
+	return c
+}
+
+func (c Call) optimize() Expr {
+	args := make([]Expr, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.optimize()
+	}
+	return Call{c.callee.optimize(), c.paren, args}
+}
+
+func (g Get) optimize() Expr {
+	g.object = g.object.optimize()
+	return g
+}
+
+func (s Set) optimize() Expr {
+	s.object = s.object.optimize()
+	s.value = s.value.optimize()
+	return s
+}
+
+func (t *This) optimize() Expr {
+	return t
+}
+
+func (l ListLiteral) optimize() Expr {
+	elements := make([]Expr, len(l.elements))
+	for i, element := range l.elements {
+		elements[i] = element.optimize()
+	}
+	return ListLiteral{l.bracket, elements}
+}
+
+func (m MapLiteral) optimize() Expr {
+	keys := make([]Expr, len(m.keys))
+	values := make([]Expr, len(m.values))
+	for i, key := range m.keys {
+		keys[i] = key.optimize()
+		values[i] = m.values[i].optimize()
+	}
+	return MapLiteral{m.brace, keys, values}
+}
+
+func (i Index) optimize() Expr {
+	i.object = i.object.optimize()
+	i.index = i.index.optimize()
+	return i
+}
+
+func (i IndexSet) optimize() Expr {
+	i.object = i.object.optimize()
+	i.index = i.index.optimize()
+	i.value = i.value.optimize()
+	return i
+}
+
+func (s Stringify) optimize() Expr {
+	s.expression = s.expression.optimize()
+	if literal, ok := s.expression.(Literal); ok {
+		return Literal{stringValue(toString(literal.value))}
+	}
+	return s
+}
+
+func (l Lambda) optimize() Expr {
+	return Lambda{l.declaration.optimizeBody()}
+}
+
+func (s *Super) optimize() Expr {
+	return s
+}
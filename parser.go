@@ -1,12 +1,18 @@
 package main
 
-import "reflect"
+import "strings"
 
 type Parser struct {
 	// token流
 	tokens []Token
 	// 当前解析token的位置
 	current int
+	// 当前所处的循环嵌套层数
+	loopDepth int
+	// 当前所处的try语句中body和catch块的嵌套层数
+	tryDepth int
+	// 语法分析中遇到的全部错误
+	errors ErrorList
 }
 
 func _Parser(tokens []Token) *Parser {
@@ -16,19 +22,39 @@ func _Parser(tokens []Token) *Parser {
 	}
 }
 
-func (parser *Parser) parse() []Stmt {
+func (parser *Parser) parse() ([]Stmt, error) {
 	stmts := make([]Stmt, 0)
 	for !parser.eof() {
 		stmts = append(stmts, parser.declaration())
 	}
-	return stmts
+	if len(parser.errors) > 0 {
+		return nil, parser.errors
+	}
+	return stmts, nil
 }
 
 /*  ===================  Statement  ===================  */
 
-// 函数声明，变量声明，其他语句
-func (parser *Parser) declaration() Stmt {
-	if parser.match(FUN) {
+// 类声明，函数声明，变量声明，其他语句
+// 遇到语法错误时记录错误并同步到下一条语句，继续分析后面的代码
+func (parser *Parser) declaration() (stmt Stmt) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			parser.errors = append(parser.errors, err)
+			parser.synchronize()
+			stmt = nil
+		}
+	}()
+	if parser.match(CLASS) {
+		return parser.classDeclaration()
+	}
+	// fun后紧跟函数名时是函数声明，否则是以匿名函数开头的表达式语句
+	if parser.peek().tokenType == FUN && parser.peekNext().tokenType == IDENTIFIER {
+		parser.next()
 		return parser.functionDeclaration()
 	}
 	if parser.match(VAR) {
@@ -57,15 +83,63 @@ func (parser *Parser) statement() Stmt {
 	if parser.match(RETURN) {
 		return parser.returnStatement()
 	}
+	if parser.match(IMPORT) {
+		return parser.importStatement()
+	}
+	if parser.match(THROW) {
+		return parser.throwStatement()
+	}
+	if parser.match(TRY) {
+		return parser.tryStatement()
+	}
+	if parser.match(BREAK) {
+		return parser.breakStatement()
+	}
+	if parser.match(CONTINUE) {
+		return parser.continueStatement()
+	}
 	return parser.exprStatement()
 }
 
+// 类声明和定义
+func (parser *Parser) classDeclaration() Stmt {
+	// 类名称
+	name := parser.consume(IDENTIFIER, "Expect class name.")
+
+	// 父类名称
+	var superclass Expr
+	if parser.match(LESS) {
+		super := parser.consume(IDENTIFIER, "Expect superclass name.")
+		if super.lexeme == name.lexeme {
+			parser.report(super.line, "A class can't inherit from itself.")
+		}
+		superclass = &Variable{super, -1, 0}
+	}
+
+	parser.consume(LEFT_BRACE, "Expect '{' before class body.")
+	// 方法定义
+	methods := make([]*functionStmt, 0)
+	for parser.peek().tokenType != RIGHT_BRACE && !parser.eof() {
+		methods = append(methods, parser.functionDeclaration().(*functionStmt))
+	}
+	parser.consume(RIGHT_BRACE, "Expect '}' after class body.")
+
+	return classStmt{name, superclass, methods}
+}
+
 // 函数声明和定义
 func (parser *Parser) functionDeclaration() Stmt {
 	// 函数名称
 	name := parser.consume(IDENTIFIER, "Expect function name.")
 
 	parser.consume(LEFT_PAREN, "Expect '(' after function name.")
+	params, stmts := parser.functionBody()
+
+	return &functionStmt{name: name, params: params, stmts: stmts}
+}
+
+// 函数的形式参数和函数体
+func (parser *Parser) functionBody() ([]Token, []Stmt) {
 	// 形式参数
 	params := make([]Token, 0)
 	if parser.peek().tokenType != RIGHT_PAREN {
@@ -79,14 +153,20 @@ func (parser *Parser) functionDeclaration() Stmt {
 	parser.consume(RIGHT_PAREN, "Expect ')' after parameters.")
 
 	parser.consume(LEFT_BRACE, "Expect '{' before function body.")
-	// 函数体语句
+	// 函数体语句，函数体内的break和continue不能跳出外层循环，return不受外层try语句影响
+	defer func(enclosingLoop int, enclosingTry int) {
+		parser.loopDepth = enclosingLoop
+		parser.tryDepth = enclosingTry
+	}(parser.loopDepth, parser.tryDepth)
+	parser.loopDepth = 0
+	parser.tryDepth = 0
 	stmts := make([]Stmt, 0)
-	for parser.peek().tokenType != RIGHT_BRACE {
+	for parser.peek().tokenType != RIGHT_BRACE && !parser.eof() {
 		stmts = append(stmts, parser.declaration())
 	}
 	parser.consume(RIGHT_BRACE, "Expect '}' after block.")
 
-	return functionStmt{name, params, stmts}
+	return params, stmts
 }
 
 // 非函数变量声明和定义
@@ -116,7 +196,85 @@ func (parser *Parser) returnStatement() Stmt {
 	}
 
 	parser.consume(SEMICOLON, "Expect ';' after return value.")
-	return returnStmt{keyword, value}
+	// try语句的body和catch块中的调用出错时需要被捕获，不能作为尾调用
+	return returnStmt{keyword, value, parser.tryDepth == 0}
+}
+
+// import语句：import "path"; 或 import "path" as name;
+func (parser *Parser) importStatement() Stmt {
+	keyword := parser.previous()
+	path := parser.consume(STRING, "Expect module path after 'import'.")
+	var alias *Token
+	// as只在import语句中有特殊含义，不作为保留字
+	if parser.peek().tokenType == IDENTIFIER && parser.peek().lexeme == "as" {
+		parser.next()
+		name := parser.consume(IDENTIFIER, "Expect module alias after 'as'.")
+		alias = &name
+	}
+	parser.consume(SEMICOLON, "Expect ';' after import.")
+	return importStmt{keyword, path, alias}
+}
+
+// throw语句
+func (parser *Parser) throwStatement() Stmt {
+	keyword := parser.previous()
+	value := parser.expression()
+	parser.consume(SEMICOLON, "Expect ';' after thrown value.")
+	return throwStmt{keyword, value}
+}
+
+// try/catch/finally语句
+func (parser *Parser) tryStatement() Stmt {
+	keyword := parser.previous()
+	parser.consume(LEFT_BRACE, "Expect '{' after 'try'.")
+	defer func(enclosingTry int) {
+		parser.tryDepth = enclosingTry
+	}(parser.tryDepth)
+	parser.tryDepth++
+	body := parser.blockStatement()
+
+	var catchName Token
+	var catchBranch Stmt
+	if parser.match(CATCH) {
+		parser.consume(LEFT_PAREN, "Expect '(' after 'catch'.")
+		catchName = parser.consume(IDENTIFIER, "Expect error variable name.")
+		parser.consume(RIGHT_PAREN, "Expect ')' after error variable name.")
+		parser.consume(LEFT_BRACE, "Expect '{' before catch body.")
+		catchBranch = parser.blockStatement()
+	}
+
+	// finally块中的return在整个try语句结束后才返回，可以作为尾调用
+	parser.tryDepth--
+	var finallyBranch Stmt
+	if parser.match(FINALLY) {
+		parser.consume(LEFT_BRACE, "Expect '{' after 'finally'.")
+		finallyBranch = parser.blockStatement()
+	}
+
+	if catchBranch == nil && finallyBranch == nil {
+		parser.report(parser.peek().line, "Expect 'catch' or 'finally' after try block.")
+	}
+	return tryStmt{keyword, body, catchName, catchBranch, finallyBranch}
+}
+
+// break语句
+func (parser *Parser) breakStatement() Stmt {
+	keyword := parser.previous()
+	if parser.loopDepth == 0 {
+		parser.report(keyword.line, "Can't use 'break' outside of a loop.")
+	}
+	parser.consume(SEMICOLON, "Expect ';' after 'break'.")
+	return breakStmt{keyword}
+}
+
+// continue语句
+func (parser *Parser) continueStatement() Stmt {
+	keyword := parser.previous()
+	if parser.loopDepth == 0 {
+		parser.report(keyword.line, "Can't use 'continue' outside of a loop.")
+	}
+	parser.consume(SEMICOLON, "Expect ';' after 'continue'.")
+	return continueStmt{keyword}
 }
 
 // for语句（解语法糖构造while语句）
@@ -148,24 +306,19 @@ func (parser *Parser) forStatement() Stmt {
 	parser.consume(RIGHT_PAREN, "Expect ')' after for clauses.")
 
 	// 循环体语句
-	body := parser.statement()
-
-	// 将自增语句添加到循环体语句末尾
-	if increment != nil {
-		body = blockStmt{[]Stmt{body, exprStmt{increment}}}
-	}
+	body := parser.loopBody()
 
 	// 条件语句为空时，将true填入while的条件表达式
 	if condition == nil {
-		condition = Literal{true}
+		condition = Literal{boolValue(true)}
 	}
 
-	// 构造while语句
-	var loop Stmt = whileStmt{condition, body}
+	// 构造while语句，自增表达式在每轮循环体之后执行，continue也不会跳过它
+	var loop Stmt = whileStmt{condition, body, increment}
 
 	// 初始化语句不为空时，将其插入while语句前
 	if initializer != nil {
-		loop = blockStmt{[]Stmt{initializer, loop}}
+		loop = &blockStmt{stmts: []Stmt{initializer, loop}}
 	}
 
 	return loop
@@ -178,9 +331,18 @@ func (parser *Parser) whileStatement() Stmt {
 	condition := parser.expression()
 	parser.consume(RIGHT_PAREN, "Expect ')' after condition.")
 	// while循环体
-	body := parser.statement()
+	body := parser.loopBody()
+
+	return whileStmt{condition, body, nil}
+}
 
-	return whileStmt{condition, body}
+// 循环体语句，解析期间记录循环嵌套层数
+func (parser *Parser) loopBody() Stmt {
+	parser.loopDepth++
+	defer func() {
+		parser.loopDepth--
+	}()
+	return parser.statement()
 }
 
 // if语句
@@ -202,11 +364,11 @@ func (parser *Parser) ifStatement() Stmt {
 // 块语句
 func (parser *Parser) blockStatement() Stmt {
 	stmts := make([]Stmt, 0)
-	for parser.peek().tokenType != RIGHT_BRACE {
+	for parser.peek().tokenType != RIGHT_BRACE && !parser.eof() {
 		stmts = append(stmts, parser.declaration())
 	}
 	parser.consume(RIGHT_BRACE, "Expect '}' after block.")
-	return blockStmt{stmts}
+	return &blockStmt{stmts: stmts}
 }
 
 // print语句
@@ -230,17 +392,54 @@ func (parser *Parser) expression() Expr {
 	return parser.assignment()
 }
 
-// { "=" }
+// { "=", "+=", "-=", "*=", "/=" }
 func (parser *Parser) assignment() Expr {
-	left := parser.or()
+	left := parser.conditional()
+	if parser.match(PLUS_EQUAL, MINUS_EQUAL, STAR_EQUAL, SLASH_EQUAL) {
+		// 复合赋值解语法糖：a += b => a = a + b
+		operator := parser.previous()
+		right := parser.assignment()
+		if target, ok := left.(*Variable); ok {
+			return &Assign{target.name, Binary{target, arithmetic(operator), right}, -1, 0}
+		}
+		parser.report(operator.line, "Invalid assignment target.")
+		return left
+	}
 	if parser.match(EQUAL) {
 		equal := parser.previous()
 		right := parser.assignment()
-		if reflect.TypeOf(left) == reflect.TypeOf(Variable{}) {
-			name := left.(Variable).name
-			return Assign{name, right}
+		switch target := left.(type) {
+		case *Variable:
+			return &Assign{target.name, right, -1, 0}
+		case Get:
+			return Set{target.object, target.name, right}
+		case Index:
+			return IndexSet{target.object, target.bracket, target.index, right}
 		}
-		exitWithErr(equal.line, "Invalid assignment target.")
+		parser.report(equal.line, "Invalid assignment target.")
+	}
+	return left
+}
+
+// { "?:" } 右结合
+func (parser *Parser) conditional() Expr {
+	condition := parser.coalesce()
+	if parser.match(QUESTION) {
+		thenBranch := parser.expression()
+		parser.consume(COLON, "Expect ':' after then branch of conditional expression.")
+		elseBranch := parser.conditional()
+		return Conditional{condition, thenBranch, elseBranch}
+	}
+	return condition
+}
+
+// { "??" }
+func (parser *Parser) coalesce() Expr {
+	left := parser.or()
+	for parser.match(QUESTION_QUESTION) {
+		operator := parser.previous()
+		right := parser.or()
+		left = Logical{left, operator, right}
 	}
 	return left
 }
@@ -280,8 +479,52 @@ func (parser *Parser) equality() Expr {
 
 // { ">", ">=", "<", "<=" }
 func (parser *Parser) comparison() Expr {
-	left := parser.term()
+	left := parser.bitOr()
 	for parser.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL) {
+		operator := parser.previous()
+		right := parser.bitOr()
+		left = Binary{left, operator, right}
+	}
+	return left
+}
+
+// { "|" }
+func (parser *Parser) bitOr() Expr {
+	left := parser.bitXor()
+	for parser.match(PIPE) {
+		operator := parser.previous()
+		right := parser.bitXor()
+		left = Binary{left, operator, right}
+	}
+	return left
+}
+
+// { "^" }
+func (parser *Parser) bitXor() Expr {
+	left := parser.bitAnd()
+	for parser.match(CARET) {
+		operator := parser.previous()
+		right := parser.bitAnd()
+		left = Binary{left, operator, right}
+	}
+	return left
+}
+
+// { "&" }
+func (parser *Parser) bitAnd() Expr {
+	left := parser.shift()
+	for parser.match(AMPERSAND) {
+		operator := parser.previous()
+		right := parser.shift()
+		left = Binary{left, operator, right}
+	}
+	return left
+}
+
+// { "<<", ">>" }
+func (parser *Parser) shift() Expr {
+	left := parser.term()
+	for parser.match(LESS_LESS, GREATER_GREATER) {
 		operator := parser.previous()
 		right := parser.term()
 		left = Binary{left, operator, right}
@@ -300,10 +543,10 @@ func (parser *Parser) term() Expr {
 	return left
 }
 
-// { "*", "/" }
+// { "*", "/", "%" }
 func (parser *Parser) factor() Expr {
 	left := parser.unary()
-	for parser.match(SLASH, STAR) {
+	for parser.match(SLASH, STAR, PERCENT) {
 		operator := parser.previous()
 		right := parser.unary()
 		left = Binary{left, operator, right}
@@ -311,17 +554,65 @@ func (parser *Parser) factor() Expr {
 	return left
 }
 
-// { "!", "-" }
+// { "!", "-", "~", "++", "--" }
 func (parser *Parser) unary() Expr {
-	if parser.match(BANG, MINUS) {
+	if parser.match(BANG, MINUS, TILDE) {
 		operator := parser.previous()
 		right := parser.unary()
 		return Unary{operator, right}
 	}
-	return parser.call()
+	if parser.match(PLUS_PLUS, MINUS_MINUS) {
+		// 前置自增自减解语法糖：++a => a = a + 1
+		operator := parser.previous()
+		right := parser.unary()
+		if target, ok := right.(*Variable); ok {
+			return &Assign{target.name, Binary{target, arithmetic(operator), Literal{numberValue(1)}}, -1, 0}
+		}
+		parser.report(operator.line, "Invalid assignment target.")
+		return right
+	}
+	return parser.power()
+}
+
+// { "**" } 右结合，优先级高于一元运算符：-2 ** 2 == -(2 ** 2)
+func (parser *Parser) power() Expr {
+	left := parser.postfix()
+	if parser.match(STAR_STAR) {
+		operator := parser.previous()
+		right := parser.unary()
+		return Binary{left, operator, right}
+	}
+	return left
 }
 
-// { call-function }
+// { "++", "--" } 后置自增自减，表达式的值为变量修改前的值
+func (parser *Parser) postfix() Expr {
+	left := parser.call()
+	if parser.match(PLUS_PLUS, MINUS_MINUS) {
+		operator := parser.previous()
+		if target, ok := left.(*Variable); ok {
+			return Postfix{target, &Assign{target.name, Binary{target, arithmetic(operator), Literal{numberValue(1)}}, -1, 0}}
+		}
+		parser.report(operator.line, "Invalid assignment target.")
+	}
+	return left
+}
+
+// 复合赋值和自增自减运算符对应的算术运算符
+func arithmetic(operator Token) Token {
+	switch operator.tokenType {
+	case PLUS_EQUAL, PLUS_PLUS:
+		return _Token(PLUS, "+", nil, operator.line)
+	case MINUS_EQUAL, MINUS_MINUS:
+		return _Token(MINUS, "-", nil, operator.line)
+	case STAR_EQUAL:
+		return _Token(STAR, "*", nil, operator.line)
+	default:
+		return _Token(SLASH, "/", nil, operator.line)
+	}
+}
+
+// { call-function, ".", "[" }
 func (parser *Parser) call() Expr {
 	callee := parser.primary()
 	for {
@@ -337,6 +628,13 @@ func (parser *Parser) call() Expr {
 			}
 			paren := parser.consume(RIGHT_PAREN, "Expect ')' after arguments.")
 			callee = Call{callee, paren, args}
+		} else if parser.match(DOT) {
+			name := parser.consume(IDENTIFIER, "Expect property name after '.'.")
+			callee = Get{callee, name}
+		} else if parser.match(LEFT_BRACKET) {
+			index := parser.expression()
+			bracket := parser.consume(RIGHT_BRACKET, "Expect ']' after index.")
+			callee = Index{callee, bracket, index}
 		} else {
 			break
 		}
@@ -344,36 +642,110 @@ func (parser *Parser) call() Expr {
 	return callee
 }
 
-// { "true", "false", "nil", Number, String, "(" }
+// { "true", "false", "nil", "this", "super", "fun", Number, String, "(", "[", "{" }
 func (parser *Parser) primary() Expr {
 	if parser.match(TRUE) {
-		return Literal{true}
+		return Literal{boolValue(true)}
 	}
 	if parser.match(FALSE) {
-		return Literal{false}
+		return Literal{boolValue(false)}
 	}
 	if parser.match(NIL) {
-		return Literal{nil}
+		return Literal{nilValue}
+	}
+	if parser.match(THIS) {
+		return &This{parser.previous(), -1, 0}
+	}
+	if parser.match(SUPER) {
+		keyword := parser.previous()
+		parser.consume(DOT, "Expect '.' after 'super'.")
+		method := parser.consume(IDENTIFIER, "Expect superclass method name.")
+		return &Super{keyword, method, -1, 0}
+	}
+	if parser.match(FUN) {
+		// 匿名函数没有名称，只保留所在行号
+		keyword := parser.previous()
+		parser.consume(LEFT_PAREN, "Expect '(' after 'fun'.")
+		params, stmts := parser.functionBody()
+		return Lambda{&functionStmt{name: _Token(FUN, "", nil, keyword.line), params: params, stmts: stmts}}
+	}
+	if parser.match(INTERPOLATION) {
+		return parser.interpolation()
 	}
 	if parser.match(NUMBER, STRING) {
-		return Literal{parser.previous().literal}
+		return Literal{literalValue(parser.previous().literal)}
 	}
 	if parser.match(LEFT_PAREN) {
 		expr := parser.expression()
 		parser.consume(RIGHT_PAREN, "Expect ')' after expression.")
 		return Grouping{expr}
 	}
+	if parser.match(LEFT_BRACKET) {
+		elements := make([]Expr, 0)
+		if parser.peek().tokenType != RIGHT_BRACKET {
+			for {
+				elements = append(elements, parser.expression())
+				if !parser.match(COMMA) {
+					break
+				}
+			}
+		}
+		bracket := parser.consume(RIGHT_BRACKET, "Expect ']' after list elements.")
+		return ListLiteral{bracket, elements}
+	}
+	if parser.match(LEFT_BRACE) {
+		keys := make([]Expr, 0)
+		values := make([]Expr, 0)
+		if parser.peek().tokenType != RIGHT_BRACE {
+			for {
+				keys = append(keys, parser.expression())
+				parser.consume(COLON, "Expect ':' after map key.")
+				values = append(values, parser.expression())
+				if !parser.match(COMMA) {
+					break
+				}
+			}
+		}
+		brace := parser.consume(RIGHT_BRACE, "Expect '}' after map entries.")
+		return MapLiteral{brace, keys, values}
+	}
 	if parser.match(IDENTIFIER) {
-		return Variable{parser.previous()}
+		return &Variable{parser.previous(), -1, 0}
 	}
-	exitWithErr(parser.peek().line, "Unexpected '"+parser.peek().lexeme+"' at here.")
+	parseError(parser.peek().line, "Unexpected '"+parser.peek().lexeme+"' at here.")
 	return nil
 }
 
+// 字符串插值解语法糖："a${x}b" => "a" + toString(x) + "b"
+func (parser *Parser) interpolation() Expr {
+	var expr Expr = Literal{literalValue(parser.previous().literal)}
+	for {
+		// 插值表达式为空时，紧接着的就是从'}'开始的字符串剩余部分
+		if next := parser.peek(); (next.tokenType == STRING || next.tokenType == INTERPOLATION) && strings.HasPrefix(next.lexeme, "}") {
+			parseError(next.line, "Expect expression in string interpolation.")
+		}
+		plus := _Token(PLUS, "+", nil, parser.previous().line)
+		expr = Binary{expr, plus, Stringify{parser.expression()}}
+		if parser.match(INTERPOLATION) {
+			expr = Binary{expr, plus, Literal{literalValue(parser.previous().literal)}}
+			continue
+		}
+		tail := parser.consume(STRING, "Expect end of string interpolation.")
+		return Binary{expr, plus, Literal{literalValue(tail.literal)}}
+	}
+}
+
 func (parser *Parser) peek() Token {
 	return parser.tokens[parser.current]
 }
 
+func (parser *Parser) peekNext() Token {
+	if parser.eof() {
+		return parser.peek()
+	}
+	return parser.tokens[parser.current+1]
+}
+
 func (parser *Parser) eof() bool {
 	return parser.peek().tokenType == EOF
 }
@@ -398,9 +770,31 @@ func (parser *Parser) match(types ...uint8) bool {
 	return false
 }
 
+// 记录错误但不打断当前的语法分析
+func (parser *Parser) report(line int, message string) {
+	parser.errors = append(parser.errors, &ParseError{line, message})
+}
+
+// 丢弃token直到下一条语句的开始，用于从语法错误中恢复
+func (parser *Parser) synchronize() {
+	if !parser.eof() {
+		parser.next()
+	}
+	for !parser.eof() {
+		if parser.previous().tokenType == SEMICOLON {
+			return
+		}
+		switch parser.peek().tokenType {
+		case CLASS, FUN, VAR, FOR, IF, WHILE, PRINT, RETURN, THROW, TRY, IMPORT:
+			return
+		}
+		parser.next()
+	}
+}
+
 func (parser *Parser) consume(expected uint8, message string) Token {
 	if parser.peek().tokenType != expected {
-		exitWithErr(parser.peek().line, message)
+		parseError(parser.peek().line, message)
 	}
 	return parser.next()
 }
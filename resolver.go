@@ -0,0 +1,348 @@
+package main
+
+// 当前所处的函数类型
+const (
+	noneFunction uint8 = iota
+	plainFunction
+	methodFunction
+	initializerFunction
+)
+
+// 当前所处的类类型
+const (
+	noneClass uint8 = iota
+	plainClass
+	subClass
+)
+
+// 局部作用域中的变量
+type binding struct {
+	slot    int  // 按声明顺序分配的槽位
+	defined bool // 是否已完成定义
+}
+
+// Resolver 在解释执行前静态解析每个变量引用所在的作用域距离和槽位，并检查语义错误
+type Resolver struct {
+	// 局部作用域栈
+	scopes []map[string]binding
+	// 当前所处的函数类型
+	currentFunction uint8
+	// 当前所处的类类型
+	currentClass uint8
+}
+
+func _Resolver() *Resolver {
+	return &Resolver{
+		scopes:          []map[string]binding{},
+		currentFunction: noneFunction,
+		currentClass:    noneClass,
+	}
+}
+
+// 解析全部语句，返回遇到的第一个错误
+func (resolver *Resolver) resolveAll(stmts []Stmt) (err error) {
+	defer catch(&err)
+	resolver.resolve(stmts)
+	return nil
+}
+
+// 解析所有语句
+func (resolver *Resolver) resolve(stmts []Stmt) {
+	for _, stmt := range stmts {
+		stmt.resolve(resolver)
+	}
+}
+
+func (resolver *Resolver) beginScope() {
+	resolver.scopes = append(resolver.scopes, map[string]binding{})
+}
+
+func (resolver *Resolver) endScope() {
+	resolver.scopes = resolver.scopes[:len(resolver.scopes)-1]
+}
+
+// 当前作用域中已分配的槽位数，运行时据此预先分配作用域的全部槽位
+func (resolver *Resolver) scopeSize() int {
+	return len(resolver.scopes[len(resolver.scopes)-1])
+}
+
+// 在当前作用域中声明变量，此时变量尚不可用
+func (resolver *Resolver) declare(name Token) {
+	if len(resolver.scopes) == 0 {
+		return
+	}
+	scope := resolver.scopes[len(resolver.scopes)-1]
+	if _, ok := scope[name.lexeme]; ok {
+		parseError(name.line, "Already a variable with this name in this scope.")
+	}
+	scope[name.lexeme] = binding{slot: len(scope)}
+}
+
+// 在当前作用域中完成变量定义，未声明的变量直接分配槽位
+func (resolver *Resolver) define(name string) {
+	if len(resolver.scopes) == 0 {
+		return
+	}
+	scope := resolver.scopes[len(resolver.scopes)-1]
+	variable, ok := scope[name]
+	if !ok {
+		variable.slot = len(scope)
+	}
+	variable.defined = true
+	scope[name] = variable
+}
+
+// 计算变量所在作用域与当前作用域的距离及其槽位，全局变量的距离为-1
+func (resolver *Resolver) resolveLocal(name Token) (depth int, slot int) {
+	for i := len(resolver.scopes) - 1; i >= 0; i-- {
+		if variable, ok := resolver.scopes[i][name.lexeme]; ok {
+			return len(resolver.scopes) - 1 - i, variable.slot
+		}
+	}
+	return -1, 0
+}
+
+// 函数调用时参数和函数体语句共用同一个作用域
+func (resolver *Resolver) resolveFunction(function *functionStmt, functionType uint8) {
+	enclosing := resolver.currentFunction
+	resolver.currentFunction = functionType
+	resolver.beginScope()
+	for _, param := range function.params {
+		resolver.declare(param)
+		resolver.define(param.lexeme)
+	}
+	resolver.resolve(function.stmts)
+	function.size = resolver.scopeSize()
+	resolver.endScope()
+	resolver.currentFunction = enclosing
+}
+
+/*  ===================  Statement  ===================  */
+
+func (e exprStmt) resolve(resolver *Resolver) {
+	e.expr.resolve(resolver)
+}
+
+func (p printStmt) resolve(resolver *Resolver) {
+	p.expr.resolve(resolver)
+}
+
+func (v varStmt) resolve(resolver *Resolver) {
+	resolver.declare(v.name)
+	if v.initializer != nil {
+		v.initializer.resolve(resolver)
+	}
+	resolver.define(v.name.lexeme)
+}
+
+func (b *blockStmt) resolve(resolver *Resolver) {
+	resolver.beginScope()
+	resolver.resolve(b.stmts)
+	b.size = resolver.scopeSize()
+	resolver.endScope()
+}
+
+func (i ifStmt) resolve(resolver *Resolver) {
+	i.condition.resolve(resolver)
+	i.thenBranch.resolve(resolver)
+	if i.elseBranch != nil {
+		i.elseBranch.resolve(resolver)
+	}
+}
+
+func (w whileStmt) resolve(resolver *Resolver) {
+	w.condition.resolve(resolver)
+	w.body.resolve(resolver)
+	if w.increment != nil {
+		w.increment.resolve(resolver)
+	}
+}
+
+func (f *functionStmt) resolve(resolver *Resolver) {
+	// 先定义函数名再解析函数体，使函数可以递归调用自身
+	resolver.declare(f.name)
+	resolver.define(f.name.lexeme)
+	resolver.resolveFunction(f, plainFunction)
+}
+
+func (c classStmt) resolve(resolver *Resolver) {
+	enclosing := resolver.currentClass
+	resolver.currentClass = plainClass
+	resolver.declare(c.name)
+	resolver.define(c.name.lexeme)
+
+	if c.superclass != nil {
+		resolver.currentClass = subClass
+		c.superclass.resolve(resolver)
+		resolver.beginScope()
+		resolver.define("super")
+	}
+
+	resolver.beginScope()
+	resolver.define("this")
+	for _, method := range c.methods {
+		functionType := methodFunction
+		if method.name.lexeme == "init" {
+			functionType = initializerFunction
+		}
+		resolver.resolveFunction(method, functionType)
+	}
+	resolver.endScope()
+
+	if c.superclass != nil {
+		resolver.endScope()
+	}
+	resolver.currentClass = enclosing
+}
+
+func (r returnStmt) resolve(resolver *Resolver) {
+	if resolver.currentFunction == noneFunction {
+		parseError(r.keyword.line, "Can't return from top-level code.")
+	}
+	if r.value != nil {
+		if resolver.currentFunction == initializerFunction {
+			parseError(r.keyword.line, "Can't return a value from an initializer.")
+		}
+		r.value.resolve(resolver)
+	}
+}
+
+func (i importStmt) resolve(resolver *Resolver) {
+	// 模块只能在顶层代码中导入，导入的名称都是全局变量
+	if resolver.currentFunction != noneFunction || len(resolver.scopes) > 0 {
+		parseError(i.keyword.line, "Can't import outside of top-level code.")
+	}
+}
+
+func (t throwStmt) resolve(resolver *Resolver) {
+	t.value.resolve(resolver)
+}
+
+func (t tryStmt) resolve(resolver *Resolver) {
+	t.body.resolve(resolver)
+	if t.catchBranch != nil {
+		resolver.beginScope()
+		resolver.declare(t.catchName)
+		resolver.define(t.catchName.lexeme)
+		t.catchBranch.resolve(resolver)
+		resolver.endScope()
+	}
+	if t.finallyBranch != nil {
+		t.finallyBranch.resolve(resolver)
+	}
+}
+
+func (b breakStmt) resolve(resolver *Resolver) {}
+
+func (c continueStmt) resolve(resolver *Resolver) {}
+
+/*  ===================  Expression  ===================  */
+
+func (l Literal) resolve(resolver *Resolver) {}
+
+func (u Unary) resolve(resolver *Resolver) {
+	u.right.resolve(resolver)
+}
+
+func (b Binary) resolve(resolver *Resolver) {
+	b.left.resolve(resolver)
+	b.right.resolve(resolver)
+}
+
+func (g Grouping) resolve(resolver *Resolver) {
+	g.expression.resolve(resolver)
+}
+
+func (v *Variable) resolve(resolver *Resolver) {
+	if len(resolver.scopes) > 0 {
+		if variable, ok := resolver.scopes[len(resolver.scopes)-1][v.name.lexeme]; ok && !variable.defined {
+			parseError(v.name.line, "Can't read local variable in its own initializer.")
+		}
+	}
+	v.depth, v.slot = resolver.resolveLocal(v.name)
+}
+
+func (a *Assign) resolve(resolver *Resolver) {
+	a.value.resolve(resolver)
+	a.depth, a.slot = resolver.resolveLocal(a.name)
+}
+
+func (p Postfix) resolve(resolver *Resolver) {
+	p.variable.resolve(resolver)
+	p.assign.resolve(resolver)
+}
+
+func (l Logical) resolve(resolver *Resolver) {
+	l.left.resolve(resolver)
+	l.right.resolve(resolver)
+}
+
+func (c Conditional) resolve(resolver *Resolver) {
+	c.condition.resolve(resolver)
+	c.thenBranch.resolve(resolver)
+	c.elseBranch.resolve(resolver)
+}
+
+func (c Call) resolve(resolver *Resolver) {
+	c.callee.resolve(resolver)
+	for _, arg := range c.args {
+		arg.resolve(resolver)
+	}
+}
+
+func (g Get) resolve(resolver *Resolver) {
+	g.object.resolve(resolver)
+}
+
+func (s Set) resolve(resolver *Resolver) {
+	s.object.resolve(resolver)
+	s.value.resolve(resolver)
+}
+
+func (t *This) resolve(resolver *Resolver) {
+	if resolver.currentClass == noneClass {
+		parseError(t.keyword.line, "Can't use 'this' outside of a class.")
+	}
+	t.depth, t.slot = resolver.resolveLocal(t.keyword)
+}
+
+func (l ListLiteral) resolve(resolver *Resolver) {
+	for _, element := range l.elements {
+		element.resolve(resolver)
+	}
+}
+
+func (m MapLiteral) resolve(resolver *Resolver) {
+	for i, key := range m.keys {
+		key.resolve(resolver)
+		m.values[i].resolve(resolver)
+	}
+}
+
+func (i Index) resolve(resolver *Resolver) {
+	i.object.resolve(resolver)
+	i.index.resolve(resolver)
+}
+
+func (i IndexSet) resolve(resolver *Resolver) {
+	i.object.resolve(resolver)
+	i.index.resolve(resolver)
+	i.value.resolve(resolver)
+}
+
+func (s Stringify) resolve(resolver *Resolver) {
+	s.expression.resolve(resolver)
+}
+
+func (l Lambda) resolve(resolver *Resolver) {
+	resolver.resolveFunction(l.declaration, plainFunction)
+}
+
+func (s *Super) resolve(resolver *Resolver) {
+	if resolver.currentClass == noneClass {
+		parseError(s.keyword.line, "Can't use 'super' outside of a class.")
+	} else if resolver.currentClass != subClass {
+		parseError(s.keyword.line, "Can't use 'super' in a class with no superclass.")
+	}
+	s.depth, s.slot = resolver.resolveLocal(s.keyword)
+}
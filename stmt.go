@@ -1,8 +1,21 @@
 package main
 
+// 语句执行结束后产生的控制流信号
+type signal uint8
+
+const (
+	sigNone     signal = iota // 正常执行完毕
+	sigReturn                 // 遇到return语句，需要一直展开到函数调用处
+	sigBreak                  // 遇到break语句，跳出最内层循环
+	sigContinue               // 遇到continue语句，进入最内层循环的下一轮
+)
+
 type (
 	Stmt interface {
-		exec(interpreter *Interpreter)
+		exec(interpreter *Interpreter) signal
+		resolve(resolver *Resolver)
+		compile(compiler *Compiler)
+		optimize() Stmt
 	}
 
 	exprStmt struct {
@@ -20,6 +33,7 @@ type (
 
 	blockStmt struct {
 		stmts []Stmt
+		size  int // 语句块中局部变量的数量，由Resolver填写
 	}
 
 	ifStmt struct {
@@ -31,75 +45,200 @@ type (
 	whileStmt struct {
 		condition Expr
 		body      Stmt
+		increment Expr // for语句的自增表达式，每轮循环体结束后（包括continue）执行
 	}
 
 	functionStmt struct {
 		name   Token
 		params []Token
 		stmts  []Stmt
+		size   int // 参数和函数体中局部变量的数量，由Resolver填写
+	}
+
+	classStmt struct {
+		name       Token
+		superclass Expr // 父类表达式，没有继承时为nil
+		methods    []*functionStmt
 	}
 
 	returnStmt struct {
 		keyword Token
 		value   Expr
+		tail    bool // 返回值中的函数调用能否作为尾调用执行
+	}
+
+	importStmt struct {
+		keyword Token
+		path    Token
+		alias   *Token // import "x" as y; 中的y，没有as时为nil
+	}
+
+	throwStmt struct {
+		keyword Token
+		value   Expr
+	}
+
+	tryStmt struct {
+		keyword       Token
+		body          Stmt
+		catchName     Token // catch语句绑定的变量名
+		catchBranch   Stmt  // 没有catch时为nil
+		finallyBranch Stmt  // 没有finally时为nil
+	}
+
+	breakStmt struct {
+		keyword Token
+	}
+
+	continueStmt struct {
+		keyword Token
 	}
 )
 
-func (e exprStmt) exec(interpreter *Interpreter) {
+func (e exprStmt) exec(interpreter *Interpreter) signal {
 	e.expr.eval(interpreter)
+	return sigNone
 }
 
-func (p printStmt) exec(interpreter *Interpreter) {
+func (p printStmt) exec(interpreter *Interpreter) signal {
 	value := p.expr.eval(interpreter)
 	out(toString(value) + "\n")
+	return sigNone
 }
 
-func (v varStmt) exec(interpreter *Interpreter) {
-	var value interface{}
+func (v varStmt) exec(interpreter *Interpreter) signal {
+	var value Value
 	if v.initializer != nil {
 		value = v.initializer.eval(interpreter)
 	}
-	interpreter.local.define(v.name.lexeme, value)
+	interpreter.define(v.name.lexeme, value)
+	return sigNone
 }
 
-func (b blockStmt) exec(interpreter *Interpreter) {
+func (b *blockStmt) exec(interpreter *Interpreter) signal {
 	father := interpreter.local
-	child := &Table{
-		father: father,
-		values: map[string]interface{}{},
-	}
+	child := _Environment(father, b.size)
 	interpreter.enterScope(child)
 	defer interpreter.enterScope(father)
-	for _, stmt := range b.stmts {
-		stmt.exec(interpreter)
-	}
+	return interpreter.execAll(b.stmts)
 }
 
-func (i ifStmt) exec(interpreter *Interpreter) {
+func (i ifStmt) exec(interpreter *Interpreter) signal {
 	if isTrue(i.condition.eval(interpreter)) {
-		i.thenBranch.exec(interpreter)
+		return i.thenBranch.exec(interpreter)
 	} else {
 		if i.elseBranch != nil {
-			i.elseBranch.exec(interpreter)
+			return i.elseBranch.exec(interpreter)
 		}
 	}
+	return sigNone
 }
 
-func (w whileStmt) exec(interpreter *Interpreter) {
+func (w whileStmt) exec(interpreter *Interpreter) signal {
 	for isTrue(w.condition.eval(interpreter)) {
-		w.body.exec(interpreter)
+		sig := w.body.exec(interpreter)
+		if sig == sigBreak {
+			break
+		}
+		if sig == sigReturn {
+			return sig
+		}
+		if w.increment != nil {
+			w.increment.eval(interpreter)
+		}
 	}
+	return sigNone
+}
+
+func (f *functionStmt) exec(interpreter *Interpreter) signal {
+	// 捕获函数定义时的作用域，形成闭包
+	fun := &Function{f, interpreter.local, false, interpreter.module}
+	interpreter.define(f.name.lexeme, objectValue(fun))
+	return sigNone
 }
 
-func (f functionStmt) exec(interpreter *Interpreter) {
-	fun := Function{f}
-	interpreter.local.define(f.name.lexeme, fun)
+func (c classStmt) exec(interpreter *Interpreter) signal {
+	var superclass *Class
+	// 方法的闭包作用域，存在父类时在其中定义super
+	env := interpreter.local
+	if c.superclass != nil {
+		class, ok := c.superclass.eval(interpreter).asObject().(*Class)
+		if !ok {
+			runtimeError(c.name.line, "Superclass must be a class.")
+		}
+		superclass = class
+		env = _Environment(interpreter.local, 1, objectValue(superclass))
+	}
+	methods := make(map[string]*Function, len(c.methods))
+	for _, method := range c.methods {
+		methods[method.name.lexeme] = &Function{method, env, method.name.lexeme == "init", interpreter.module}
+	}
+	interpreter.define(c.name.lexeme, objectValue(&Class{c.name.lexeme, superclass, methods}))
+	return sigNone
 }
 
-func (r returnStmt) exec(interpreter *Interpreter) {
-	var result interface{}
-	if r.value != nil {
+func (r returnStmt) exec(interpreter *Interpreter) signal {
+	var result Value
+	if r.value != nil && r.tail {
+		result = interpreter.evalTail(r.value)
+	} else if r.value != nil {
 		result = r.value.eval(interpreter)
 	}
-	interpreter.returnStack = append(interpreter.returnStack, result)
+	interpreter.returnValue = result
+	return sigReturn
+}
+
+func (i importStmt) exec(interpreter *Interpreter) signal {
+	module := interpreter.load(i.keyword, i.path.literal.(string))
+	if i.alias != nil {
+		interpreter.define(i.alias.lexeme, objectValue(module))
+		return sigNone
+	}
+	// 没有别名时将模块的顶层定义全部导入当前作用域
+	for name, value := range module.globals.values {
+		interpreter.define(name, value)
+	}
+	return sigNone
+}
+
+func (t throwStmt) exec(interpreter *Interpreter) signal {
+	throw(t.keyword.line, t.value.eval(interpreter))
+	return sigNone
+}
+
+func (t tryStmt) exec(interpreter *Interpreter) signal {
+	sig, err := interpreter.protect(func() signal {
+		return t.body.exec(interpreter)
+	})
+	if err != nil && t.catchBranch != nil {
+		// catch绑定的变量位于单独的作用域中
+		father := interpreter.local
+		catchLocal := _Environment(father, 1, err.loxValue())
+		sig, err = interpreter.protect(func() signal {
+			interpreter.enterScope(catchLocal)
+			defer interpreter.enterScope(father)
+			return t.catchBranch.exec(interpreter)
+		})
+	}
+	// finally块总会执行，其中的return、break、continue会丢弃尚未处理的错误
+	if t.finallyBranch != nil {
+		// finally中的函数调用会覆盖尚未返回的值，执行完后需要恢复
+		returnValue := interpreter.returnValue
+		if finallySig := t.finallyBranch.exec(interpreter); finallySig != sigNone {
+			return finallySig
+		}
+		interpreter.returnValue = returnValue
+	}
+	if err != nil {
+		panic(err)
+	}
+	return sig
+}
+
+func (b breakStmt) exec(interpreter *Interpreter) signal {
+	return sigBreak
+}
+
+func (c continueStmt) exec(interpreter *Interpreter) signal {
+	return sigContinue
 }
@@ -1,33 +1,68 @@
 package main
 
+// Table 全局变量表，每个模块一个，外层为原生函数表
 type Table struct {
 	father *Table
-	values map[string]interface{}
+	values map[string]Value
 }
 
-func (table *Table) define(name string, value interface{}) {
+func (table *Table) define(name string, value Value) {
 	table.values[name] = value
 }
 
-func (table *Table) get(name Token) interface{} {
+func (table *Table) get(name Token) Value {
 	value, ok := table.values[name.lexeme]
 	if !ok {
 		if table.father != nil {
 			return table.father.get(name)
 		}
-		exitWithErr(name.line, "Undefined variable '"+name.lexeme+"'.")
+		runtimeError(name.line, "Undefined variable '"+name.lexeme+"'.")
 	}
 	return value
 }
 
-func (table *Table) assign(name Token, value interface{}) {
-	_, ok := table.values[name.lexeme]
-	if !ok {
-		if table.father != nil {
-			table.father.assign(name, value)
-			return
-		}
-		exitWithErr(name.line, "Undefined variable '"+name.lexeme+"'.")
-	}
+// 外层的原生函数表由所有模块共享，给原生函数赋值时在当前模块中定义同名变量
+func (table *Table) assign(name Token, value Value) {
+	table.get(name)
 	table.values[name.lexeme] = value
 }
+
+// Environment 局部作用域，变量按Resolver分配的槽位保存在数组中
+type Environment struct {
+	father  *Environment
+	slots   []Value
+	defined int // 已定义的变量数
+}
+
+// 创建有size个槽位的作用域，values依次占据最前面的槽位
+// 槽位在创建时全部分配，闭包在变量定义完成前读取到的是nil
+func _Environment(father *Environment, size int, values ...Value) *Environment {
+	if len(values) == size {
+		return &Environment{father, values, size}
+	}
+	slots := make([]Value, size)
+	return &Environment{father, slots, copy(slots, values)}
+}
+
+// 变量按声明的顺序依次定义，与Resolver分配的槽位一致
+func (env *Environment) define(value Value) {
+	env.slots[env.defined] = value
+	env.defined++
+}
+
+// 沿作用域链向外走distance层
+func (env *Environment) ancestor(distance int) *Environment {
+	target := env
+	for i := 0; i < distance; i++ {
+		target = target.father
+	}
+	return target
+}
+
+func (env *Environment) getAt(distance int, slot int) Value {
+	return env.ancestor(distance).slots[slot]
+}
+
+func (env *Environment) assignAt(distance int, slot int, value Value) {
+	env.ancestor(distance).slots[slot] = value
+}
@@ -6,13 +6,22 @@ const (
 	RIGHT_PAREN
 	LEFT_BRACE
 	RIGHT_BRACE
+	LEFT_BRACKET
+	RIGHT_BRACKET
 	COMMA
+	COLON
 	DOT
 	MINUS
 	PLUS
 	SEMICOLON
 	SLASH
 	STAR
+	PERCENT
+	AMPERSAND
+	PIPE
+	CARET
+	TILDE
+	QUESTION
 
 	// One or two character tokens.
 	BANG
@@ -23,24 +32,45 @@ const (
 	GREATER_EQUAL
 	LESS
 	LESS_EQUAL
+	STAR_STAR
+	LESS_LESS
+	GREATER_GREATER
+	PLUS_EQUAL
+	MINUS_EQUAL
+	STAR_EQUAL
+	SLASH_EQUAL
+	PLUS_PLUS
+	MINUS_MINUS
+	QUESTION_QUESTION
 
 	// Literals.
 	IDENTIFIER
 	STRING
+	INTERPOLATION // 字符串插值中位于"${"之前的部分
 	NUMBER
 
 	// Keywords.
 	AND
+	BREAK
+	CATCH
+	CLASS
+	CONTINUE
 	ELSE
 	FALSE
+	FINALLY
 	FUN
 	FOR
 	IF
+	IMPORT
 	NIL
 	OR
 	PRINT
 	RETURN
+	SUPER
+	THIS
+	THROW
 	TRUE
+	TRY
 	VAR
 	WHILE
 
@@ -53,6 +83,7 @@ type Token struct {
 	lexeme    string
 	literal   interface{}
 	line      int
+	column    int // 所在列，从1开始按字符计数
 }
 
 func _Token(tokenType uint8, lexeme string, literal interface{}, line int) Token {
@@ -63,16 +94,28 @@ func findType(text string) uint8 {
 	switch text {
 	case "and":
 		return AND
+	case "break":
+		return BREAK
+	case "catch":
+		return CATCH
+	case "class":
+		return CLASS
+	case "continue":
+		return CONTINUE
 	case "else":
 		return ELSE
 	case "false":
 		return FALSE
+	case "finally":
+		return FINALLY
 	case "for":
 		return FOR
 	case "fun":
 		return FUN
 	case "if":
 		return IF
+	case "import":
+		return IMPORT
 	case "nil":
 		return NIL
 	case "or":
@@ -81,8 +124,16 @@ func findType(text string) uint8 {
 		return PRINT
 	case "return":
 		return RETURN
+	case "super":
+		return SUPER
+	case "this":
+		return THIS
+	case "throw":
+		return THROW
 	case "true":
 		return TRUE
+	case "try":
+		return TRY
 	case "var":
 		return VAR
 	case "while":
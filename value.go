@@ -0,0 +1,84 @@
+package main
+
+// 值的类型标签
+const (
+	VAL_NIL uint8 = iota
+	VAL_BOOL
+	VAL_NUMBER
+	VAL_STRING
+	VAL_OBJECT // 函数、类、实例、列表、字典等引用类型
+)
+
+// Value Lox运行时的值，由类型标签和对应的数据组成
+// 布尔值和数字保存在number中，字符串和对象保存在ref中，两个值相等当且仅当它们按==比较相等
+type Value struct {
+	kind   uint8
+	number float64
+	ref    interface{}
+}
+
+var nilValue = Value{}
+
+func boolValue(b bool) Value {
+	if b {
+		return Value{kind: VAL_BOOL, number: 1}
+	}
+	return Value{kind: VAL_BOOL}
+}
+
+func numberValue(n float64) Value {
+	return Value{kind: VAL_NUMBER, number: n}
+}
+
+func stringValue(s string) Value {
+	return Value{kind: VAL_STRING, ref: s}
+}
+
+func objectValue(object interface{}) Value {
+	return Value{kind: VAL_OBJECT, ref: object}
+}
+
+// 将词法分析得到的字面量转换为Value
+func literalValue(literal interface{}) Value {
+	switch literal := literal.(type) {
+	case bool:
+		return boolValue(literal)
+	case float64:
+		return numberValue(literal)
+	case string:
+		return stringValue(literal)
+	}
+	return nilValue
+}
+
+func (v Value) isNil() bool {
+	return v.kind == VAL_NIL
+}
+
+func (v Value) isNumber() bool {
+	return v.kind == VAL_NUMBER
+}
+
+func (v Value) isString() bool {
+	return v.kind == VAL_STRING
+}
+
+func (v Value) asBool() bool {
+	return v.number != 0
+}
+
+func (v Value) asNumber() float64 {
+	return v.number
+}
+
+func (v Value) asString() string {
+	return v.ref.(string)
+}
+
+// 对象值对应的Go对象，其他类型的值返回nil
+func (v Value) asObject() interface{} {
+	if v.kind != VAL_OBJECT {
+		return nil
+	}
+	return v.ref
+}
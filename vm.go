@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// 调用栈的最大深度
+const maxFrames = 1 << 16
+
+// Prototype 编译后的函数，运行时通过OP_CLOSURE创建对应的闭包
+type Prototype struct {
+	name         string
+	arity        int
+	upvalueCount int
+	chunk        Chunk
+}
+
+// Closure 虚拟机中的函数对象
+type Closure struct {
+	proto    *Prototype
+	upvalues []*Upvalue
+	module   *Module // 函数定义所在的模块，其中的全局变量都在该模块的全局变量表中
+}
+
+// Upvalue 闭包捕获的变量，所在函数返回前指向栈上的槽位，之后保存变量的值
+type Upvalue struct {
+	slot   int
+	open   bool
+	closed Value
+	next   *Upvalue // 下一个仍指向栈上的上值，按槽位从大到小排列
+}
+
+// VMClass 虚拟机中的类对象，继承时父类的方法被复制到子类中
+type VMClass struct {
+	name    string
+	methods map[string]*Closure
+}
+
+// VMInstance 虚拟机中的类实例
+type VMInstance struct {
+	class  *VMClass
+	fields map[string]Value
+}
+
+// BoundMethod 绑定到实例上的方法
+type BoundMethod struct {
+	receiver Value
+	method   *Closure
+}
+
+// 函数调用对应的栈帧
+type frame struct {
+	closure *Closure
+	ip      int // 下一条要执行的指令
+	base    int // 槽位0在值栈中的下标
+}
+
+// try语句注册的错误处理位置
+type handler struct {
+	frames int // 注册时的栈帧数
+	stack  int // 注册时的值栈高度
+	ip     int // 出错后跳转到的指令
+}
+
+// VM 执行字节码的栈式虚拟机
+type VM struct {
+	stack        []Value
+	frames       []frame
+	handlers     []handler
+	openUpvalues *Upvalue
+	builtins     *Table             // 原生函数表，所有模块的全局变量表都以它为外层作用域
+	files        fs.FS              // import语句读取模块的文件系统
+	entry        *Module            // 入口模块
+	modules      map[string]*Module // 已加载的模块，以路径为键
+	optimize     bool               // 导入的模块是否也进行优化
+}
+
+func _VM() *VM {
+	builtins := &Table{nil, map[string]Value{}}
+	for _, native := range natives {
+		builtins.define(native.name, objectValue(native))
+	}
+	entry := &Module{globals: &Table{builtins, map[string]Value{}}, loaded: true}
+	return &VM{
+		stack:    make([]Value, 0, 256),
+		builtins: builtins,
+		entry:    entry,
+		modules:  map[string]*Module{"": entry},
+	}
+}
+
+// 执行编译后的脚本函数
+func (vm *VM) interpret(script *Prototype) (err error) {
+	defer catch(&err)
+	closure := &Closure{proto: script, module: vm.entry}
+	vm.push(objectValue(closure))
+	vm.call(closure, 0, 0)
+	vm.execute(0)
+	return nil
+}
+
+// 执行指令直到栈帧数回到stop，运行时错误被try捕获后从对应的位置继续执行
+func (vm *VM) execute(stop int) {
+	for !vm.resume(stop) {
+	}
+}
+
+// 执行指令直到栈帧数回到stop，返回false表示运行时错误已被捕获，需要重新进入执行循环
+// 只处理在stop之上的栈帧中注册的try，其余错误继续向外传递
+func (vm *VM) resume(stop int) (done bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		e, ok := r.(*RuntimeError)
+		if !ok {
+			panic(r)
+		}
+		vm.locate(e)
+		if len(vm.handlers) == 0 || vm.handlers[len(vm.handlers)-1].frames <= stop {
+			panic(r)
+		}
+		vm.unwind(e)
+	}()
+	vm.run(stop)
+	return true
+}
+
+// 运行时错误离开出错的栈帧前记录所在模块，入口模块中的错误不记录模块路径
+func (vm *VM) locate(e *RuntimeError) {
+	if e.located || len(vm.frames) == 0 {
+		return
+	}
+	e.located = true
+	if module := vm.frames[len(vm.frames)-1].closure.module; module != vm.entry {
+		e.Module = module.path
+	}
+}
+
+// 展开到最内层的try：恢复注册时的栈帧和值栈，压入错误后跳转到处理代码
+func (vm *VM) unwind(e *RuntimeError) {
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.closeUpvalues(h.stack)
+	vm.frames = vm.frames[:h.frames]
+	vm.stack = vm.stack[:h.stack]
+	vm.push(objectValue(e))
+	vm.frames[h.frames-1].ip = h.ip
+}
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) peek(distance int) Value {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// 调用栈顶下方第argc个位置上的对象
+func (vm *VM) callValue(callee Value, argc int, line int) {
+	switch callee := callee.asObject().(type) {
+	case *Closure:
+		vm.call(callee, argc, line)
+		return
+	case *BoundMethod:
+		vm.stack[len(vm.stack)-1-argc] = callee.receiver
+		vm.call(callee.method, argc, line)
+		return
+	case *VMClass:
+		instance := &VMInstance{callee, map[string]Value{}}
+		vm.stack[len(vm.stack)-1-argc] = objectValue(instance)
+		if initializer, ok := callee.methods["init"]; ok {
+			vm.call(initializer, argc, line)
+		} else if argc != 0 {
+			runtimeError(line, fmt.Sprintf("Expect %d arguments but get %d", 0, argc))
+		}
+		return
+	case *Native:
+		if arity := callee.arity(); arity >= 0 && arity != argc {
+			runtimeError(line, fmt.Sprintf("Expect %d arguments but get %d", arity, argc))
+		}
+		args := make([]Value, argc)
+		copy(args, vm.stack[len(vm.stack)-argc:])
+		result := callee.call(nil, _Token(RIGHT_PAREN, ")", nil, line), args)
+		vm.stack = vm.stack[:len(vm.stack)-1-argc]
+		vm.push(result)
+		return
+	}
+	runtimeError(line, "Can only call functions and classes.")
+}
+
+// 为闭包调用创建新的栈帧
+func (vm *VM) call(closure *Closure, argc int, line int) {
+	if argc != closure.proto.arity {
+		runtimeError(line, fmt.Sprintf("Expect %d arguments but get %d", closure.proto.arity, argc))
+	}
+	if len(vm.frames) == maxFrames {
+		runtimeError(line, "Stack overflow.")
+	}
+	vm.frames = append(vm.frames, frame{closure, 0, len(vm.stack) - 1 - argc})
+}
+
+// 捕获栈上slot处的变量，同一个变量只对应一个上值
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	var prev *Upvalue
+	current := vm.openUpvalues
+	for current != nil && current.slot > slot {
+		prev, current = current, current.next
+	}
+	if current != nil && current.slot == slot {
+		return current
+	}
+	created := &Upvalue{slot: slot, open: true, next: current}
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.next = created
+	}
+	return created
+}
+
+// 关闭所有槽位不小于last的上值，将变量的值从栈上移入上值
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.slot]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+	}
+}
+
+func (vm *VM) getUpvalue(upvalue *Upvalue) Value {
+	if upvalue.open {
+		return vm.stack[upvalue.slot]
+	}
+	return upvalue.closed
+}
+
+func (vm *VM) setUpvalue(upvalue *Upvalue, value Value) {
+	if upvalue.open {
+		vm.stack[upvalue.slot] = value
+	} else {
+		upvalue.closed = value
+	}
+}
+
+// 指令执行循环，栈帧数回到stop时返回
+func (vm *VM) run(stop int) {
+	current := &vm.frames[len(vm.frames)-1]
+	chunk := &current.closure.proto.chunk
+	globals := current.closure.module.globals
+
+	readByte := func() byte {
+		b := chunk.code[current.ip]
+		current.ip++
+		return b
+	}
+	readShort := func() int {
+		value := chunk.readShort(current.ip)
+		current.ip += 2
+		return value
+	}
+	// 当前指令对应的行号
+	line := func() int {
+		return chunk.lines[current.ip-1]
+	}
+	// 调用或返回后切换到新的栈帧
+	switchFrame := func() {
+		current = &vm.frames[len(vm.frames)-1]
+		chunk = &current.closure.proto.chunk
+		globals = current.closure.module.globals
+	}
+
+	for {
+		switch op := readByte(); op {
+		case OP_CONSTANT:
+			vm.push(chunk.constants[readShort()])
+		case OP_NIL:
+			vm.push(nilValue)
+		case OP_TRUE:
+			vm.push(boolValue(true))
+		case OP_FALSE:
+			vm.push(boolValue(false))
+		case OP_POP:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case OP_GET_LOCAL:
+			vm.push(vm.stack[current.base+int(readByte())])
+		case OP_SET_LOCAL:
+			vm.stack[current.base+int(readByte())] = vm.peek(0)
+		case OP_GET_UPVALUE:
+			vm.push(vm.getUpvalue(current.closure.upvalues[readByte()]))
+		case OP_SET_UPVALUE:
+			vm.setUpvalue(current.closure.upvalues[readByte()], vm.peek(0))
+		case OP_GET_GLOBAL:
+			name := chunk.constants[readShort()].asString()
+			vm.push(globals.get(Token{lexeme: name, line: line()}))
+		case OP_DEFINE_GLOBAL:
+			globals.define(chunk.constants[readShort()].asString(), vm.pop())
+		case OP_SET_GLOBAL:
+			name := chunk.constants[readShort()].asString()
+			globals.assign(Token{lexeme: name, line: line()}, vm.peek(0))
+		case OP_GET_PROPERTY:
+			name := chunk.constants[readShort()].asString()
+			switch object := vm.peek(0).asObject().(type) {
+			case *VMInstance:
+				if value, ok := object.fields[name]; ok {
+					vm.stack[len(vm.stack)-1] = value
+				} else if method, ok := object.class.methods[name]; ok {
+					vm.stack[len(vm.stack)-1] = objectValue(&BoundMethod{vm.peek(0), method})
+				} else {
+					runtimeError(line(), "Undefined property '"+name+"'.")
+				}
+			case *ErrorObject:
+				vm.stack[len(vm.stack)-1] = object.get(Token{lexeme: name, line: line()})
+			case *Module:
+				vm.stack[len(vm.stack)-1] = object.get(Token{lexeme: name, line: line()})
+			default:
+				runtimeError(line(), "Only instances have properties.")
+			}
+		case OP_SET_PROPERTY:
+			name := chunk.constants[readShort()].asString()
+			instance, ok := vm.peek(1).asObject().(*VMInstance)
+			if !ok {
+				runtimeError(line(), "Only instances have fields.")
+			}
+			value := vm.pop()
+			instance.fields[name] = value
+			vm.stack[len(vm.stack)-1] = value
+		case OP_GET_SUPER:
+			name := chunk.constants[readShort()].asString()
+			superclass := vm.pop().asObject().(*VMClass)
+			method, ok := superclass.methods[name]
+			if !ok {
+				runtimeError(line(), "Undefined property '"+name+"'.")
+			}
+			vm.stack[len(vm.stack)-1] = objectValue(&BoundMethod{vm.peek(0), method})
+		case OP_GET_INDEX:
+			index := vm.pop()
+			vm.stack[len(vm.stack)-1] = indexGet(Token{line: line()}, vm.peek(0), index)
+		case OP_SET_INDEX:
+			value, index := vm.pop(), vm.pop()
+			bracket := Token{line: line()}
+			switch container := vm.peek(0).asObject().(type) {
+			case *List:
+				container.elements[container.index(bracket, index)] = value
+			case *Map:
+				container.set(bracket, index, value)
+			default:
+				runtimeError(line(), "Only lists and maps support index assignment.")
+			}
+			vm.stack[len(vm.stack)-1] = value
+		case OP_LIST:
+			count := readShort()
+			elements := make([]Value, count)
+			copy(elements, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(objectValue(&List{elements}))
+		case OP_MAP:
+			count := readShort()
+			brace := Token{line: line()}
+			result := &Map{map[Value]Value{}}
+			pairs := vm.stack[len(vm.stack)-2*count:]
+			for i := 0; i < count; i++ {
+				result.set(brace, pairs[2*i], pairs[2*i+1])
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(objectValue(result))
+		case OP_UNARY:
+			operator := chunk.operators[readShort()]
+			vm.stack[len(vm.stack)-1] = unaryOp(operator, vm.peek(0))
+		case OP_BINARY:
+			operator := chunk.operators[readShort()]
+			right := vm.pop()
+			vm.stack[len(vm.stack)-1] = binaryOp(operator, vm.peek(0), right)
+		case OP_STRINGIFY:
+			vm.stack[len(vm.stack)-1] = stringValue(toString(vm.peek(0)))
+		case OP_PRINT:
+			out(toString(vm.pop()) + "\n")
+		case OP_JUMP:
+			offset := readShort()
+			current.ip += offset
+		case OP_JUMP_IF_FALSE:
+			offset := readShort()
+			if !isTrue(vm.peek(0)) {
+				current.ip += offset
+			}
+		case OP_JUMP_IF_NOT_NIL:
+			offset := readShort()
+			if !vm.peek(0).isNil() {
+				current.ip += offset
+			}
+		case OP_LOOP:
+			offset := readShort()
+			current.ip -= offset
+		case OP_CALL:
+			argc := int(readByte())
+			vm.callValue(vm.peek(argc), argc, line())
+			switchFrame()
+		case OP_CLOSURE:
+			proto := chunk.constants[readShort()].asObject().(*Prototype)
+			closure := &Closure{proto, make([]*Upvalue, proto.upvalueCount), current.closure.module}
+			for i := range closure.upvalues {
+				isLocal, index := readByte(), int(readByte())
+				if isLocal == 1 {
+					closure.upvalues[i] = vm.captureUpvalue(current.base + index)
+				} else {
+					closure.upvalues[i] = current.closure.upvalues[index]
+				}
+			}
+			vm.push(objectValue(closure))
+		case OP_CLOSE_UPVALUE:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case OP_RETURN:
+			result := vm.pop()
+			base := current.base
+			vm.closeUpvalues(base)
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.stack = vm.stack[:base]
+			if len(vm.frames) == stop {
+				return
+			}
+			vm.push(result)
+			switchFrame()
+		case OP_CLASS:
+			name := chunk.constants[readShort()].asString()
+			vm.push(objectValue(&VMClass{name, map[string]*Closure{}}))
+		case OP_INHERIT:
+			superclass, ok := vm.peek(1).asObject().(*VMClass)
+			if !ok {
+				runtimeError(line(), "Superclass must be a class.")
+			}
+			subclass := vm.pop().asObject().(*VMClass)
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+		case OP_METHOD:
+			name := chunk.constants[readShort()].asString()
+			method := vm.pop().asObject().(*Closure)
+			vm.peek(0).asObject().(*VMClass).methods[name] = method
+		case OP_IMPORT:
+			name := chunk.constants[readShort()].asString()
+			module := vm.load(line(), current.closure.module, name)
+			// 模块在新的栈帧中执行，栈帧数组可能已经重新分配
+			switchFrame()
+			vm.push(objectValue(module))
+		case OP_IMPORT_ALL:
+			// 没有别名时将模块的顶层定义全部导入当前模块
+			for name, value := range vm.pop().asObject().(*Module).globals.values {
+				globals.define(name, value)
+			}
+		case OP_THROW:
+			throw(line(), vm.pop())
+		case OP_TRY:
+			offset := readShort()
+			vm.handlers = append(vm.handlers, handler{len(vm.frames), len(vm.stack), current.ip + offset})
+		case OP_END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case OP_CATCH:
+			vm.stack[len(vm.stack)-1] = vm.peek(0).asObject().(*RuntimeError).loxValue()
+		case OP_RETHROW:
+			panic(vm.pop().asObject().(*RuntimeError))
+		default:
+			panic(fmt.Sprintf("unknown opcode %d", op))
+		}
+	}
+}